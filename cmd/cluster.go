@@ -0,0 +1,438 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	mastersOrderFirst = "first"
+	mastersOrderLast  = "last"
+
+	patchedSignalExec       = "exec"
+	patchedSignalAnnotation = "annotation"
+	patchedSignalBootID     = "boot-id"
+
+	nodeStatusPatched      = "patched"
+	nodeStatusSkipped      = "skipped"
+	nodeStatusFailed       = "failed"
+	nodeStatusNotProcessed = "not-processed"
+)
+
+// patchClusterOptions is the options used by patch-cluster
+type patchClusterOptions struct {
+	MastersOrder      string
+	MaxUnavailable    int
+	PatchedSignal     string
+	PatchedCommand    string
+	PatchedAnnotation string
+	PatchedTimeout    time.Duration
+	PollInterval      time.Duration
+	Downtime          downtimeOptions
+}
+
+// nodePatchResult is the result of the patch of one node
+type nodePatchResult struct {
+	Node     string
+	Role     string
+	Status   string
+	ExitCode int
+	Duration time.Duration
+	Error    string
+}
+
+// PatchCluster permit to patch all nodes of the cluster one by one
+// Exit 0: all nodes are patched
+// Exit 1: Somethink wrong on some nodes, they are skipped
+// Exit 2: Somethink wrong, patchmanagement is stopped because of node is broken
+func PatchCluster(c *cli.Context) error {
+
+	cmd, err := newCmd(c)
+	if err != nil {
+		log.Errorf("Can't connect on kubernetes: %s", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancelFunc := getContext(c)
+	if cancelFunc != nil {
+		defer cancelFunc()
+	}
+
+	drainOpts := getDrainOptions(c)
+	gates := getGateTimeouts(c)
+	jobs := getHookRun(c)
+	opts := patchClusterOptions{
+		MastersOrder:      c.String("masters-order"),
+		MaxUnavailable:    c.Int("max-unavailable"),
+		PatchedSignal:     c.String("patched-signal"),
+		PatchedCommand:    c.String("patched-command"),
+		PatchedAnnotation: c.String("patched-annotation"),
+		PatchedTimeout:    c.Duration("patched-timeout"),
+		PollInterval:      10 * time.Second,
+		Downtime: downtimeOptions{
+			RetryDrainOnFailed: c.Bool("retry-on-drain-failed"),
+			NbRetry:            c.Int("number-retry"),
+			JobConcurrency:     c.Int("job-concurrency"),
+			Jobs:               &jobs,
+			Drain:              &drainOpts,
+			Gates:              &gates,
+			Lock:               getLockOptions(c),
+		},
+	}
+
+	results, isStopped, err := patchCluster(ctx, cmd, opts)
+	if err != nil {
+		return err
+	}
+
+	printPatchSummary(results)
+
+	os.Exit(patchClusterExitCode(results, isStopped))
+
+	return nil
+}
+
+// patchCluster permit to patch all nodes, group by group
+// It return true if patchmanagement is stopped before patch all nodes
+func patchCluster(ctx context.Context, cmd *kubetool.Kubetool, opts patchClusterOptions) (results []*nodePatchResult, isStopped bool, err error) {
+
+	if err = opts.validate(); err != nil {
+		return nil, false, err
+	}
+
+	masters, err := cmd.MasterNodes(ctx)
+	if err != nil {
+		log.Errorf("Error when list master nodes")
+		return nil, false, err
+	}
+	workers, err := cmd.WorkerNodes(ctx)
+	if err != nil {
+		log.Errorf("Error when list worker nodes")
+		return nil, false, err
+	}
+
+	// Compute the budget of nodes we can put on downtime at the same time
+	unavailableNodes, err := cmd.UnavailableNodes(ctx)
+	if err != nil {
+		log.Errorf("Error when list unavailable nodes")
+		return nil, false, err
+	}
+	budget := opts.MaxUnavailable - len(unavailableNodes)
+	if budget <= 0 {
+		return nil, false, errors.Errorf("Max unavailable budget %d is already reached, unavailable nodes: %s", opts.MaxUnavailable, strings.Join(unavailableNodes, ", "))
+	}
+	log.Infof("Patch cluster with %d node(s) on downtime at the same time", budget)
+
+	groups := orderNodes(masters, workers, opts.MastersOrder)
+	results = make([]*nodePatchResult, 0, len(masters)+len(workers))
+	for _, group := range groups {
+		for _, node := range group.nodes {
+			results = append(results, &nodePatchResult{
+				Node:   node,
+				Role:   group.role,
+				Status: nodeStatusNotProcessed,
+			})
+		}
+	}
+
+	index := 0
+	for _, group := range groups {
+		groupResults := results[index : index+len(group.nodes)]
+		index += len(group.nodes)
+
+		if isStopped {
+			break
+		}
+
+		// Only one master on downtime to keep the quorum
+		groupBudget := opts.MaxUnavailable
+		if group.role == "master" {
+			groupBudget = 1
+		}
+
+		isStopped = patchNodes(ctx, cmd, groupResults, groupBudget, opts)
+	}
+
+	return results, isStopped, nil
+}
+
+// patchNodes permit to patch nodes with at most budget nodes on the same time
+// The nodes unavailable are checked again before patch each node, so a node that become not ready during the run reduce the budget
+// It return true if patchmanagement must be stopped
+func patchNodes(ctx context.Context, cmd *kubetool.Kubetool, results []*nodePatchResult, budget int, opts patchClusterOptions) (isStopped bool) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running int
+	)
+	// Signal that a node is finished, so we check again the budget
+	finished := make(chan struct{}, len(results))
+
+	for _, result := range results {
+		if !waitBudget(ctx, cmd, budget, opts, finished, func() (int, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			return running, isStopped
+		}) {
+			// The budget is reached by other nodes, or the context is done
+			mu.Lock()
+			isStopped = isStopped || ctx.Err() == nil
+			mu.Unlock()
+			break
+		}
+
+		mu.Lock()
+		running++
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(result *nodePatchResult) {
+			defer wg.Done()
+
+			patchNode(ctx, cmd, result, opts)
+
+			mu.Lock()
+			running--
+			if result.Status == nodeStatusFailed {
+				isStopped = true
+			}
+			mu.Unlock()
+			finished <- struct{}{}
+		}(result)
+	}
+
+	wg.Wait()
+
+	return isStopped
+}
+
+// waitBudget permit to wait that one more node can be put on downtime
+// It return false when patchmanagement must be stopped: a node failed, the context is done, or the budget is reached by other nodes while no node is on downtime
+func waitBudget(ctx context.Context, cmd *kubetool.Kubetool, budget int, opts patchClusterOptions, finished <-chan struct{}, state func() (running int, isStopped bool)) bool {
+	for {
+		running, isStopped := state()
+		if isStopped || ctx.Err() != nil {
+			return false
+		}
+
+		unavailableNodes, err := cmd.UnavailableNodes(ctx)
+		if err != nil {
+			log.Errorf("Error when list unavailable nodes: %s", err.Error())
+		} else {
+			available := opts.MaxUnavailable - len(unavailableNodes)
+			if available > budget {
+				available = budget
+			}
+			if running < available {
+				return true
+			}
+			if running == 0 {
+				log.Errorf("Max unavailable budget %d is reached, unavailable nodes: %s", opts.MaxUnavailable, strings.Join(unavailableNodes, ", "))
+				return false
+			}
+			log.Infof("Wait node on downtime to be finished, %d node(s) on downtime and %d node(s) unavailable", running, len(unavailableNodes))
+		}
+
+		select {
+		case <-finished:
+		case <-time.After(opts.PollInterval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// patchNode permit to run the whole patch process on one node
+func patchNode(ctx context.Context, cmd *kubetool.Kubetool, result *nodePatchResult, opts patchClusterOptions) {
+	startTime := time.Now()
+	defer func() {
+		result.Duration = time.Since(startTime).Round(time.Second)
+	}()
+
+	log.Infof("Start to patch node %s", result.Node)
+
+	node, err := cmd.GetNode(ctx, result.Node)
+	if err != nil {
+		result.fail(kubetool.ExitCodeSkip, nodeStatusSkipped, err)
+		return
+	}
+	bootID := node.Status.NodeInfo.BootID
+
+	if err = setDowntime(ctx, cmd, result.Node, opts.Downtime); err != nil {
+		log.Error(err.Error())
+		exitCode := rescueDowntime(ctx, cmd, result.Node, opts.Downtime, err)
+		if exitCode == kubetool.ExitCodeStop {
+			result.fail(exitCode, nodeStatusFailed, err)
+		} else {
+			result.fail(exitCode, nodeStatusSkipped, err)
+		}
+		return
+	}
+
+	if err = waitPatched(ctx, cmd, result.Node, bootID, opts); err != nil {
+		log.Errorf("Error when wait node %s to be patched: %s", result.Node, err.Error())
+		result.fail(kubetool.ExitCode(err, kubetool.ExitCodeStop), nodeStatusFailed, err)
+		return
+	}
+
+	if err = unsetDowntime(ctx, cmd, result.Node, opts.Downtime); err != nil {
+		log.Errorf("Error when put node %s online: %s", result.Node, err.Error())
+		result.fail(kubetool.ExitCode(err, kubetool.ExitCodeStop), nodeStatusFailed, err)
+		return
+	}
+
+	result.Status = nodeStatusPatched
+	log.Infof("Node %s successfully patched", result.Node)
+}
+
+// waitPatched permit to wait the external signal that say node is patched
+func waitPatched(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, bootID string, opts patchClusterOptions) (err error) {
+	log.Infof("Wait node %s to be patched (signal %s)", nodeName, opts.PatchedSignal)
+
+	switch opts.PatchedSignal {
+	case patchedSignalExec:
+		ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, opts.PatchedTimeout)
+		defer cancelFunc()
+
+		command := exec.CommandContext(ctxWithTimeout, "/bin/sh", "-c", opts.PatchedCommand)
+		command.Env = append(os.Environ(), fmt.Sprintf("NODE_NAME=%s", nodeName))
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		if err = command.Run(); err != nil {
+			return errors.Wrapf(err, "Error when run patched command for node %s", nodeName)
+		}
+
+		return nil
+
+	case patchedSignalAnnotation:
+		key, value, _ := strings.Cut(opts.PatchedAnnotation, "=")
+		err = wait.PollUntilContextTimeout(ctx, opts.PollInterval, opts.PatchedTimeout, true, func(ctx context.Context) (done bool, err error) {
+			node, err := cmd.GetNode(ctx, nodeName)
+			if err != nil {
+				return false, err
+			}
+			if currentValue, ok := node.Annotations[key]; ok && (value == "" || currentValue == value) {
+				return true, nil
+			}
+			log.Debugf("Node %s not yet patched, we wait ...", nodeName)
+			return false, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Error when wait annotation %s on node %s", opts.PatchedAnnotation, nodeName)
+		}
+
+		// Consume the signal for the next patchmanagement
+		return cmd.RemoveNodeAnnotation(ctx, nodeName, key)
+
+	case patchedSignalBootID:
+		err = wait.PollUntilContextTimeout(ctx, opts.PollInterval, opts.PatchedTimeout, true, func(ctx context.Context) (done bool, err error) {
+			node, err := cmd.GetNode(ctx, nodeName)
+			if err != nil {
+				return false, err
+			}
+			if node.Status.NodeInfo.BootID != "" && node.Status.NodeInfo.BootID != bootID {
+				return true, nil
+			}
+			log.Debugf("Node %s not yet rebooted, we wait ...", nodeName)
+			return false, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Error when wait node %s to reboot", nodeName)
+		}
+
+		return nil
+
+	default:
+		return errors.Errorf("Patched signal %s not supported", opts.PatchedSignal)
+	}
+}
+
+type nodeGroup struct {
+	role  string
+	nodes []string
+}
+
+// orderNodes permit to compute the order to patch nodes
+func orderNodes(masters []string, workers []string, mastersOrder string) (groups []nodeGroup) {
+	masterGroup := nodeGroup{role: "master", nodes: masters}
+	workerGroup := nodeGroup{role: "worker", nodes: workers}
+
+	if mastersOrder == mastersOrderFirst {
+		return []nodeGroup{masterGroup, workerGroup}
+	}
+
+	return []nodeGroup{workerGroup, masterGroup}
+}
+
+func (o patchClusterOptions) validate() error {
+	if o.MastersOrder != mastersOrderFirst && o.MastersOrder != mastersOrderLast {
+		return errors.Errorf("--masters-order must be %s or %s", mastersOrderFirst, mastersOrderLast)
+	}
+	if o.MaxUnavailable < 1 {
+		return errors.New("--max-unavailable must be greater than 0")
+	}
+	// Else the nodes over the lock holders failed as locked, instead of being patched at the same time
+	if o.Downtime.Lock != nil && o.MaxUnavailable > o.Downtime.Lock.MaxHolders {
+		return errors.Errorf("--lock-max-holders (%d) must be greater or equal to --max-unavailable (%d)", o.Downtime.Lock.MaxHolders, o.MaxUnavailable)
+	}
+	switch o.PatchedSignal {
+	case patchedSignalExec:
+		if o.PatchedCommand == "" {
+			return errors.New("--patched-command must be provided when use exec signal")
+		}
+	case patchedSignalAnnotation:
+		if o.PatchedAnnotation == "" {
+			return errors.New("--patched-annotation must be provided when use annotation signal")
+		}
+	case patchedSignalBootID:
+	default:
+		return errors.Errorf("--patched-signal must be %s, %s or %s", patchedSignalExec, patchedSignalAnnotation, patchedSignalBootID)
+	}
+
+	return nil
+}
+
+func (r *nodePatchResult) fail(exitCode int, status string, err error) {
+	r.ExitCode = exitCode
+	r.Status = status
+	r.Error = err.Error()
+}
+
+// patchClusterExitCode return the worst exit code of nodes
+// The stop exit code win when patchmanagement is stopped or a node failed, whatever the exit code of node
+func patchClusterExitCode(results []*nodePatchResult, isStopped bool) (exitCode int) {
+	if isStopped {
+		return kubetool.ExitCodeStop
+	}
+	for _, result := range results {
+		if result.ExitCode == kubetool.ExitCodeStop || result.Status == nodeStatusFailed {
+			return kubetool.ExitCodeStop
+		}
+		if result.ExitCode > exitCode {
+			exitCode = result.ExitCode
+		}
+	}
+
+	return exitCode
+}
+
+// printPatchSummary print the result of each node
+func printPatchSummary(results []*nodePatchResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tROLE\tSTATUS\tEXIT CODE\tDURATION\tERROR")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", result.Node, result.Role, result.Status, result.ExitCode, result.Duration, result.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func (s *TestSuite) TestOrderNodes() {
+
+	groups := orderNodes([]string{"master1"}, []string{"worker1", "worker2"}, mastersOrderLast)
+	assert.Equal(s.T(), []nodeGroup{
+		{role: "worker", nodes: []string{"worker1", "worker2"}},
+		{role: "master", nodes: []string{"master1"}},
+	}, groups)
+
+	groups = orderNodes([]string{"master1"}, []string{"worker1", "worker2"}, mastersOrderFirst)
+	assert.Equal(s.T(), []nodeGroup{
+		{role: "master", nodes: []string{"master1"}},
+		{role: "worker", nodes: []string{"worker1", "worker2"}},
+	}, groups)
+}
+
+// When max unavailable budget is already reached by other node
+// It must return error
+func (s *TestSuite) TestPatchClusterWhenBudgetReached() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "worker1",
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	_, _, err := patchCluster(context.TODO(), cmd, patchClusterOptions{
+		MastersOrder:   mastersOrderLast,
+		MaxUnavailable: 1,
+		PatchedSignal:  patchedSignalBootID,
+		PatchedTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+	assert.Error(s.T(), err)
+}
+
+// When node is not ready
+// It must skip node without stop
+func (s *TestSuite) TestPatchClusterWhenNodeNotReady() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "worker1",
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	results, isStopped, err := patchCluster(context.TODO(), cmd, patchClusterOptions{
+		MastersOrder:   mastersOrderLast,
+		MaxUnavailable: 2,
+		PatchedSignal:  patchedSignalBootID,
+		PatchedTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), results, 1)
+	assert.Equal(s.T(), nodeStatusSkipped, results[0].Status)
+	assert.False(s.T(), isStopped)
+	assert.Equal(s.T(), kubetool.ExitCodeNodeNotReady, patchClusterExitCode(results, isStopped))
+}
+
+func (s *TestSuite) TestWaitPatched() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "worker1",
+				Annotations: map[string]string{
+					"kubetool/patched": "true",
+				},
+			},
+			Status: v1.NodeStatus{
+				NodeInfo: v1.NodeSystemInfo{
+					BootID: "new-boot-id",
+				},
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	opts := patchClusterOptions{
+		PatchedAnnotation: "kubetool/patched=true",
+		PatchedTimeout:    time.Second,
+		PollInterval:      time.Millisecond,
+	}
+
+	// With annotation
+	opts.PatchedSignal = patchedSignalAnnotation
+	err := waitPatched(context.TODO(), cmd, "worker1", "old-boot-id", opts)
+	assert.NoError(s.T(), err)
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "worker1", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.NotContains(s.T(), node.Annotations, "kubetool/patched")
+
+	// With boot ID
+	opts.PatchedSignal = patchedSignalBootID
+	err = waitPatched(context.TODO(), cmd, "worker1", "old-boot-id", opts)
+	assert.NoError(s.T(), err)
+	err = waitPatched(context.TODO(), cmd, "worker1", "new-boot-id", opts)
+	assert.Error(s.T(), err)
+
+	// With command
+	opts.PatchedSignal = patchedSignalExec
+	opts.PatchedCommand = "test \"$NODE_NAME\" = worker1"
+	err = waitPatched(context.TODO(), cmd, "worker1", "", opts)
+	assert.NoError(s.T(), err)
+	opts.PatchedCommand = "exit 1"
+	err = waitPatched(context.TODO(), cmd, "worker1", "", opts)
+	assert.Error(s.T(), err)
+}
+
+// When node failed with other exit code than stop
+// It must return the stop exit code, because of the patchmanagement is stopped
+func (s *TestSuite) TestPatchClusterExitCode() {
+	results := []*nodePatchResult{
+		{Node: "worker1", Status: nodeStatusSkipped, ExitCode: kubetool.ExitCodeNodeNotReady},
+		{Node: "worker2", Status: nodeStatusFailed, ExitCode: kubetool.ExitCodeGateFailed},
+		{Node: "worker3", Status: nodeStatusNotProcessed},
+	}
+	assert.Equal(s.T(), kubetool.ExitCodeStop, patchClusterExitCode(results, false))
+
+	results[1].Status = nodeStatusPatched
+	results[1].ExitCode = 0
+	assert.Equal(s.T(), kubetool.ExitCodeNodeNotReady, patchClusterExitCode(results, false))
+	assert.Equal(s.T(), kubetool.ExitCodeStop, patchClusterExitCode(results, true))
+}
+
+// When other node become unavailable during the run
+// It must reduce the number of nodes put on downtime at the same time
+func (s *TestSuite) TestWaitBudget() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "worker1",
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	opts := patchClusterOptions{
+		MaxUnavailable: 2,
+		PollInterval:   time.Millisecond,
+	}
+	finished := make(chan struct{})
+	state := func(running int) func() (int, bool) {
+		return func() (int, bool) { return running, false }
+	}
+
+	// One slot left
+	assert.True(s.T(), waitBudget(context.TODO(), cmd, 2, opts, finished, state(0)))
+
+	// The slot is taken, it wait until the context is done
+	ctx, cancelFunc := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancelFunc()
+	assert.False(s.T(), waitBudget(ctx, cmd, 2, opts, finished, state(1)))
+
+	// No slot left and no node on downtime
+	opts.MaxUnavailable = 1
+	assert.False(s.T(), waitBudget(context.TODO(), cmd, 2, opts, finished, state(0)))
+}
+
+// When all nodes are patched
+// It must patch masters last and return exit code 0
+func (s *TestSuite) TestPatchCluster() {
+	newNode := func(name string, labels map[string]string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		}
+	}
+	fakeClient := fake.NewSimpleClientset(
+		newNode("master1", map[string]string{"master": "true"}),
+		newNode("worker1", nil),
+		newNode("worker2", nil),
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	results, isStopped, err := patchCluster(context.TODO(), cmd, patchClusterOptions{
+		MastersOrder:   mastersOrderLast,
+		MaxUnavailable: 2,
+		PatchedSignal:  patchedSignalExec,
+		PatchedCommand: "true",
+		PatchedTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+	})
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), isStopped)
+	nodes := make([]string, 0, len(results))
+	for _, result := range results {
+		assert.Equal(s.T(), nodeStatusPatched, result.Status, result.Error)
+		nodes = append(nodes, result.Node)
+	}
+	assert.Equal(s.T(), []string{"worker1", "worker2", "master1"}, nodes)
+	assert.Equal(s.T(), 0, patchClusterExitCode(results, isStopped))
+
+	// All nodes are online
+	for _, name := range nodes {
+		node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), name, meta.GetOptions{})
+		assert.NoError(s.T(), err)
+		assert.False(s.T(), node.Spec.Unschedulable)
+		assert.NotContains(s.T(), node.Annotations, kubetool.DowntimeStateAnnotation)
+	}
+}
+
+// When many nodes can be patched at the same time with the lock
+// It must reject lock holders lower than max unavailable, and patch all nodes else
+func (s *TestSuite) TestPatchClusterWithLock() {
+	newNode := func(name string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: name,
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		}
+	}
+	fakeClient := fake.NewSimpleClientset(
+		newNode("worker1"),
+		newNode("worker2"),
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	opts := patchClusterOptions{
+		MastersOrder:   mastersOrderLast,
+		MaxUnavailable: 2,
+		PatchedSignal:  patchedSignalExec,
+		PatchedCommand: "true",
+		PatchedTimeout: time.Second,
+		PollInterval:   time.Millisecond,
+		Downtime: downtimeOptions{
+			Lock: &lockOptions{
+				Namespace:  "kube-system",
+				Holder:     "fake-holder",
+				MaxHolders: 1,
+			},
+		},
+	}
+
+	// Lock holders lower than max unavailable
+	_, _, err := patchCluster(context.TODO(), cmd, opts)
+	assert.ErrorContains(s.T(), err, "--lock-max-holders (1) must be greater or equal to --max-unavailable (2)")
+
+	// Lock holders equal to max unavailable
+	opts.Downtime.Lock.MaxHolders = 2
+	results, isStopped, err := patchCluster(context.TODO(), cmd, opts)
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), isStopped)
+	assert.Len(s.T(), results, 2)
+	for _, result := range results {
+		assert.Equal(s.T(), nodeStatusPatched, result.Status, result.Error)
+	}
+	assert.Equal(s.T(), 0, patchClusterExitCode(results, isStopped))
+
+	// All locks are released
+	leases, err := fakeClient.CoordinationV1().Leases("kube-system").List(context.TODO(), meta.ListOptions{})
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), leases.Items)
+}
@@ -15,8 +15,19 @@ func newCmd(c *cli.Context) (cmd *kubetool.Kubetool, err error) {
 	log.Debugf("Use kubeconfig: %s", c.String("kubeconfig"))
 
 	cmd, err = kubetool.NewConnexion(c.String("kubeconfig"))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.SetRetryPolicy(kubetool.RetryPolicy{
+		MaxAttempts:  c.Int("retry-max-attempts"),
+		InitialDelay: c.Duration("retry-initial-delay"),
+		Multiplier:   c.Float64("retry-multiplier"),
+		MaxDelay:     c.Duration("retry-max-delay"),
+		Jitter:       c.Float64("retry-jitter"),
+	})
 
-	return cmd, err
+	return cmd, nil
 
 }
 
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// CheckDrain permit to check if node can be drained right now
+// It return error if some pods are blocked by PodDisruptionBudget
+func CheckDrain(c *cli.Context) error {
+	cmd, err := newCmd(c)
+	if err != nil {
+		log.Errorf("Can't connect on kubernetes: %s", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancelFunc := getContext(c)
+	if cancelFunc != nil {
+		defer cancelFunc()
+	}
+
+	nodeName := c.String("node-name")
+
+	err = checkDrain(ctx, cmd, nodeName, getDrainOptions(c))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Node %s can be drained", nodeName)
+	return nil
+}
+
+func checkDrain(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts kubetool.DrainOptions) (err error) {
+	blockedPods, err := cmd.CheckDrain(ctx, nodeName, opts)
+	if err != nil {
+		log.Errorf("Error when check PodDisruptionBudget on node %s", nodeName)
+		return err
+	}
+
+	if len(blockedPods) > 0 {
+		for _, pod := range blockedPods {
+			log.Warnf("Pod %s/%s can't be evicted because of PodDisruptionBudget %s: %s", pod.Namespace, pod.Name, pod.PodDisruptionBudget, pod.Reason)
+		}
+		return kubetool.NewErrDrainBlocked(nodeName, blockedPods)
+	}
+
+	return nil
+}
+
+// getDrainOptions permit to read drain options from flags
+func getDrainOptions(c *cli.Context) kubetool.DrainOptions {
+	return kubetool.DrainOptions{
+		Force:                           c.Bool("drain-force"),
+		DeleteEmptyDirData:              c.Bool("drain-delete-emptydir-data"),
+		GracePeriodSeconds:              c.Int("drain-grace-period"),
+		Timeout:                         c.Duration("drain-timeout"),
+		PodSelector:                     c.String("drain-pod-selector"),
+		SkipWaitForDeleteTimeoutSeconds: c.Int("drain-skip-wait-for-delete-timeout"),
+		DisableEviction:                 c.Bool("drain-disable-eviction"),
+	}
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newDrainCheckObjects(disruptionsAllowed int32) []runtime.Object {
+	return []runtime.Object{
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"app": "fake",
+				},
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "StatefulSet",
+						Name:       "fake-sts",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+			Status: v1.PodStatus{
+				Conditions: []v1.PodCondition{
+					{
+						Type:   v1.PodReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "other-pod",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"app": "other",
+				},
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "ReplicaSet",
+						Name:       "other-rs",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&policy.PodDisruptionBudget{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pdb",
+				Namespace: "fake-namespace",
+			},
+			Spec: policy.PodDisruptionBudgetSpec{
+				Selector: &meta.LabelSelector{
+					MatchLabels: map[string]string{
+						"app": "fake",
+					},
+				},
+			},
+			Status: policy.PodDisruptionBudgetStatus{
+				DisruptionsAllowed: disruptionsAllowed,
+				CurrentHealthy:     3,
+				DesiredHealthy:     3,
+			},
+		},
+	}
+}
+
+// When PodDisruptionBudget allow disruption
+// It must return no error
+func (s *TestSuite) TestCheckDrainWhenAllowed() {
+	fakeClient := fake.NewSimpleClientset(newDrainCheckObjects(1)...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	err := checkDrain(context.TODO(), cmd, "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+}
+
+// When PodDisruptionBudget not allow disruption
+// It must return drain blocked error
+func (s *TestSuite) TestCheckDrainWhenBlocked() {
+	fakeClient := fake.NewSimpleClientset(newDrainCheckObjects(0)...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	blockedPods, err := cmd.CheckDrain(context.TODO(), "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), blockedPods, 1)
+	assert.Equal(s.T(), "fake-pod", blockedPods[0].Name)
+	assert.Equal(s.T(), "fake-pdb", blockedPods[0].PodDisruptionBudget)
+
+	err = checkDrain(context.TODO(), cmd, "fake-node", kubetool.DefaultDrainOptions())
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrDrainBlocked(err))
+
+	// Set downtime must stop before run pre-job and ask uncordon rescue
+	err = setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsRescueUncordon(err))
+	assert.True(s.T(), kubetool.IsErrDrainBlocked(err))
+}
+
+// When PodDisruptionBudget allow less disruptions than pods on node
+// It must not block the drain, that retry the eviction
+func (s *TestSuite) TestCheckDrainWhenLessDisruptionsThanPods() {
+	objects := newDrainCheckObjects(1)
+	objects = append(objects, &v1.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "fake-pod-2",
+			Namespace: "fake-namespace",
+			Labels: map[string]string{
+				"app": "fake",
+			},
+			OwnerReferences: []meta.OwnerReference{
+				{
+					Kind:       "StatefulSet",
+					Name:       "fake-sts",
+					Controller: ptr.To[bool](true),
+				},
+			},
+		},
+		Spec: v1.PodSpec{NodeName: "fake-node"},
+	})
+	fakeClient := fake.NewSimpleClientset(objects...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	blockedPods, err := cmd.CheckDrain(context.TODO(), "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+	assert.Empty(s.T(), blockedPods)
+
+	// When no disruption is allowed but the unhealthy pod is on node
+	pdb, err := fakeClient.PolicyV1().PodDisruptionBudgets("fake-namespace").Get(context.TODO(), "fake-pdb", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	pdb.Status.DisruptionsAllowed = 0
+	pdb.Status.CurrentHealthy = 2
+	pdb.Status.ExpectedPods = 3
+	_, err = fakeClient.PolicyV1().PodDisruptionBudgets("fake-namespace").Update(context.TODO(), pdb, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = checkDrain(context.TODO(), cmd, "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+}
+
+// When PodDisruptionBudget has minAvailable equal to replicas and the unhealthy pod is on other node
+// It must block the drain, because the healthy pods on node can't be evicted
+func (s *TestSuite) TestCheckDrainWhenMinAvailableEqualReplicas() {
+	objects := newDrainCheckObjects(0)
+	pdb := objects[len(objects)-1].(*policy.PodDisruptionBudget)
+	pdb.Spec.MinAvailable = ptr.To(intstr.FromInt32(3))
+	pdb.Status.CurrentHealthy = 2
+	pdb.Status.DesiredHealthy = 3
+	pdb.Status.ExpectedPods = 3
+	fakeClient := fake.NewSimpleClientset(objects...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	blockedPods, err := cmd.CheckDrain(context.TODO(), "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), blockedPods, 1)
+	assert.Equal(s.T(), "fake-pod", blockedPods[0].Name)
+	assert.Equal(s.T(), "0 disruptions allowed for 1 pods on node (2/3 healthy pods)", blockedPods[0].Reason)
+
+	err = checkDrain(context.TODO(), cmd, "fake-node", kubetool.DefaultDrainOptions())
+	assert.True(s.T(), kubetool.IsErrDrainBlocked(err))
+}
+
+// When drain options exclude the pod protected by PodDisruptionBudget
+// It must return no error
+func (s *TestSuite) TestCheckDrainWithDrainOptions() {
+	fakeClient := fake.NewSimpleClientset(newDrainCheckObjects(0)...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	// Pod selector not match the protected pod
+	opts := kubetool.DefaultDrainOptions()
+	opts.PodSelector = "app=other"
+	err := checkDrain(context.TODO(), cmd, "fake-node", opts)
+	assert.NoError(s.T(), err)
+
+	// Delete bypass PodDisruptionBudget
+	opts = kubetool.DefaultDrainOptions()
+	opts.DisableEviction = true
+	err = checkDrain(context.TODO(), cmd, "fake-node", opts)
+	assert.NoError(s.T(), err)
+}
+
+// When pod declare no controller and force is disabled
+// It must block the pod
+func (s *TestSuite) TestPodsForDeletionWhenNoForce() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	pods, err := cmd.PodsForDeletion(context.TODO(), "fake-node", kubetool.DefaultDrainOptions())
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), pods, 1)
+	assert.Equal(s.T(), kubetool.DrainActionForceDelete, pods[0].Action)
+
+	opts := kubetool.DefaultDrainOptions()
+	opts.Force = false
+	pods, err = cmd.PodsForDeletion(context.TODO(), "fake-node", opts)
+	assert.NoError(s.T(), err)
+	assert.Len(s.T(), pods, 1)
+	assert.Equal(s.T(), kubetool.DrainActionBlocked, pods[0].Action)
+}
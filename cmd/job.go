@@ -3,7 +3,9 @@ package cmd
 import (
 	"context"
 	"os"
-	"time"
+	"sort"
+	"strings"
+	"sync"
 
 	"emperror.dev/errors"
 	"github.com/disaster37/kubetool/v1.28/kubetool"
@@ -27,7 +29,12 @@ func RunPostJob(c *cli.Context) error {
 		defer cancelFunc()
 	}
 
-	err = runPostJob(ctx, cmd, c.String("namespace"))
+	// Each call is new run, the node is optional
+	run := getHookRun(c)
+	run.ID = kubetool.NewRunID()
+	run.NodeName = c.String("node-name")
+
+	err = runPostJob(ctx, cmd, c.String("namespace"), run)
 	if err != nil {
 		return err
 	}
@@ -54,7 +61,12 @@ func RunPreJob(c *cli.Context) error {
 		defer cancelFunc()
 	}
 
-	err = runPreJob(ctx, cmd, c.String("namespace"))
+	// Each call is new run, the node is optional
+	run := getHookRun(c)
+	run.ID = kubetool.NewRunID()
+	run.NodeName = c.String("node-name")
+
+	err = runPreJob(ctx, cmd, c.String("namespace"), run)
 	if err != nil {
 		return err
 	}
@@ -64,45 +76,284 @@ func RunPreJob(c *cli.Context) error {
 
 }
 
-func runPostJob(ctx context.Context, cmd *kubetool.Kubetool, namespace string) (err error) {
+func runPostJob(ctx context.Context, cmd *kubetool.Kubetool, namespace string, run kubetool.HookRun) (err error) {
+	return runNamespaceHooks(ctx, cmd, namespace, kubetool.HookPhasePost, run)
+}
+
+func runPreJob(ctx context.Context, cmd *kubetool.Kubetool, namespace string, run kubetool.HookRun) (err error) {
+	return runNamespaceHooks(ctx, cmd, namespace, kubetool.HookPhasePre, run)
+}
+
+// getHookRun permit to read the hook Job options from flags. The run ID and node name are not set
+func getHookRun(c *cli.Context) kubetool.HookRun {
+	run := kubetool.HookRun{
+		Timeout:            c.Duration("job-timeout"),
+		History:            c.Int("job-history"),
+		ArtifactsDir:       c.String("artifacts-dir"),
+		ArtifactsConfigMap: c.Bool("artifacts-configmap"),
+		ClusterName:        c.String("cluster-name"),
+		NodeLabels:         c.StringSlice("hook-node-labels"),
+	}
+	if run.ClusterName == "" {
+		run.ClusterName = kubetool.KubeconfigClusterName(c.String("kubeconfig"))
+	}
+	if ttl := c.Duration("job-ttl"); ttl > 0 {
+		ttlSeconds := int32(ttl.Seconds())
+		run.TTLSecondsAfterFinished = &ttlSeconds
+	}
+
+	return run
+}
+
+// runNamespaceHooks permit to run all hooks of the given phase on namespace
+func runNamespaceHooks(ctx context.Context, cmd *kubetool.Kubetool, namespace string, phase string, run kubetool.HookRun) (err error) {
 
-	// Get post job
+	// Get hooks
 	jobSpec, err := cmd.GetJobSpec(ctx, namespace)
 	if err != nil {
 		return err
 	}
-	if jobSpec == nil || jobSpec.PostJob == "" {
+	if jobSpec == nil || len(jobSpec.HooksOf(phase)) == 0 {
+		if phase == kubetool.HookPhasePre {
+			return errors.Errorf("Pre job not found in namespace %s", namespace)
+		}
 		return errors.Errorf("Post job not found in namespace %s", namespace)
 	}
 
-	// Run postjob
-	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, time.Minute*30)
-	defer cancelFunc()
-	err = cmd.RunJob(ctxWithTimeout, namespace, "post-job", jobSpec.PostJob, jobSpec.Image, jobSpec.SecretNames, "")
-	if err != nil {
+	runner := &hookRunner{
+		cmd:     cmd,
+		hookRun: run,
+		phase:   phase,
+	}
+	job := newNamespaceJob(namespace, phase, jobSpec)
+	if err = runner.checkTemplates(ctx, [][]namespaceJob{{job}}); err != nil {
 		return err
 	}
+	return runner.runNamespace(ctx, job)
+}
+
+// namespaceJob is the hooks to run on namespace during downtime
+type namespaceJob struct {
+	Namespace string
+	Hooks     []kubetool.Hook
+	Order     int
+	After     []string
+}
+
+// newNamespaceJob return the hooks of the given phase to run on namespace from its job spec
+func newNamespaceJob(namespace string, phase string, jobSpec *kubetool.Job) namespaceJob {
+	return namespaceJob{
+		Namespace: namespace,
+		Hooks:     jobSpec.HooksOf(phase),
+		Order:     jobSpec.Order,
+		After:     jobSpec.After,
+	}
+}
+
+// jobWaves permit to group the jobs in waves that must run one after another
+// A job run after the jobs of namespaces listed on its After, and after the jobs with lower order
+// The dependency on namespace without job is ignored. When reverse is true, the waves are returned in reverse order (for post-jobs)
+// It return error if the dependencies form a cycle
+func jobWaves(jobs []namespaceJob, reverse bool) (waves [][]namespaceJob, err error) {
+	remaining := make(map[string]namespaceJob, len(jobs))
+	for _, job := range jobs {
+		remaining[job.Namespace] = job
+	}
+
+	waves = make([][]namespaceJob, 0)
+	for len(remaining) > 0 {
+		// The jobs which all dependencies already run
+		ready := make([]namespaceJob, 0)
+		for _, job := range remaining {
+			isReady := true
+			for _, namespace := range job.After {
+				if _, ok := remaining[namespace]; ok && namespace != job.Namespace {
+					isReady = false
+					break
+				}
+			}
+			if isReady {
+				ready = append(ready, job)
+			}
+		}
+		if len(ready) == 0 {
+			namespaces := make([]string, 0, len(remaining))
+			for namespace := range remaining {
+				namespaces = append(namespaces, namespace)
+			}
+			sort.Strings(namespaces)
+			return nil, errors.Errorf("Dependencies between namespaces form a cycle: %s", strings.Join(namespaces, ", "))
+		}
+
+		// Only the jobs with lowest order run on this wave
+		minOrder := ready[0].Order
+		for _, job := range ready {
+			if job.Order < minOrder {
+				minOrder = job.Order
+			}
+		}
+		wave := make([]namespaceJob, 0, len(ready))
+		for _, job := range ready {
+			if job.Order == minOrder {
+				wave = append(wave, job)
+				delete(remaining, job.Namespace)
+			}
+		}
+		sort.Slice(wave, func(i, j int) bool {
+			return wave[i].Namespace < wave[j].Namespace
+		})
+		waves = append(waves, wave)
+	}
+
+	if reverse {
+		for i, j := 0, len(waves)-1; i < j; i, j = i+1, j-1 {
+			waves[i], waves[j] = waves[j], waves[i]
+		}
+	}
+
+	return waves, nil
+}
+
+// hookRunner permit to run the hooks of namespaces
+type hookRunner struct {
+	cmd     *kubetool.Kubetool
+	hookRun kubetool.HookRun
+	phase   string
+
+	// concurrency is how many namespaces run their hooks at the same time. Lower than 1 run them one by one
+	concurrency int
+
+	// report is nil when the report is disabled
+	report *downtimeReport
+
+	// isDone return true if the hook already run successfully on namespace. It can be nil
+	isDone func(namespace string, hook string) bool
+
+	// onSuccess is called one at a time after the hook run, so it can update the downtime state. It can be nil
+	onSuccess func(namespace string, hook string) error
+
+	mu sync.Mutex
+}
+
+// run permit to run the waves of jobs one after another
+// It run all namespaces of wave even if some of them failed, and return the combined errors. The next waves are not run when a wave failed
+func (r *hookRunner) run(ctx context.Context, waves [][]namespaceJob) (err error) {
+	for i, wave := range waves {
+		if err = r.runWave(ctx, wave); err != nil {
+			for _, nextWave := range waves[i+1:] {
+				for _, job := range nextWave {
+					log.Warnf("Skip %s hooks for %s because of previous hooks failed", r.phase, job.Namespace)
+				}
+			}
+			return err
+		}
+	}
 
 	return nil
 }
 
-func runPreJob(ctx context.Context, cmd *kubetool.Kubetool, namespace string) (err error) {
+// checkTemplates permit to render the templates of hooks not yet run, so the template errors are caught before run any hook
+func (r *hookRunner) checkTemplates(ctx context.Context, waves [][]namespaceJob) (err error) {
+	errs := make([]error, 0)
+	for _, wave := range waves {
+		for _, job := range wave {
+			for _, hook := range job.Hooks {
+				if !hook.HasTemplate() || (r.isDone != nil && r.isDone(job.Namespace, hook.Name)) {
+					continue
+				}
+				if err = r.cmd.CheckHookTemplates(ctx, job.Namespace, hook, r.hookRun); err != nil {
+					errs = append(errs, errors.Wrapf(err, "Error when check hook %s for %s", hook.Name, job.Namespace))
+				}
+			}
+		}
+	}
 
-	// Get pre job
-	jobSpec, err := cmd.GetJobSpec(ctx, namespace)
-	if err != nil {
-		return err
+	return errors.Combine(errs...)
+}
+
+// runWave permit to run the hooks of namespaces of one wave, with at most concurrency namespaces at the same time
+func (r *hookRunner) runWave(ctx context.Context, jobs []namespaceJob) (err error) {
+	concurrency := r.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	slots := make(chan struct{}, concurrency)
+
+	for _, job := range jobs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(job namespaceJob) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := r.runNamespace(ctx, job); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(job)
 	}
-	if jobSpec == nil || jobSpec.PreJob == "" {
-		return errors.Errorf("Pre job not found in namespace %s", namespace)
+
+	wg.Wait()
+
+	return errors.Combine(errs...)
+}
+
+// runNamespace permit to run the hooks of namespace one after another
+// It stop on first failed hook, except when its failure policy is continue
+func (r *hookRunner) runNamespace(ctx context.Context, job namespaceJob) (err error) {
+	for _, hook := range job.Hooks {
+		if r.isDone != nil && r.isDone(job.Namespace, hook.Name) {
+			log.Infof("Hook %s already run successfully for %s, skip it", hook.Name, job.Namespace)
+			r.record(job.Namespace, hook.Name, jobStatusAlreadyRun, nil)
+			continue
+		}
+
+		log.Infof("Run %s hook %s on %s", r.phase, hook.Name, job.Namespace)
+		err = r.cmd.RunHook(ctx, job.Namespace, hook, r.hookRun)
+
+		if err != nil {
+			// The veto stop the hooks, whatever the failure policy
+			if kubetool.IsErrHookVetoed(err) {
+				log.Warnf("Hook %s for %s vetoed the downtime: %s", hook.Name, job.Namespace, err.Error())
+				r.record(job.Namespace, hook.Name, jobStatusVetoed, err)
+				return errors.Wrapf(err, "Hook %s for %s vetoed the downtime", hook.Name, job.Namespace)
+			}
+			if !hook.IsContinueOnFailure() {
+				log.Errorf("Error when run hook %s for %s: %s", hook.Name, job.Namespace, err.Error())
+				r.record(job.Namespace, hook.Name, jobStatusFailed, err)
+				return errors.Wrapf(err, "Error when run hook %s for %s", hook.Name, job.Namespace)
+			}
+			log.Warnf("Error when run hook %s for %s, continue because of its failure policy: %s", hook.Name, job.Namespace, err.Error())
+			if err = r.record(job.Namespace, hook.Name, jobStatusFailedIgnored, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Infof("Run hook %s successfully for %s", hook.Name, job.Namespace)
+		if err = r.record(job.Namespace, hook.Name, jobStatusSuccess, nil); err != nil {
+			return err
+		}
 	}
 
-	// Run postjob
-	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, time.Minute*30)
-	defer cancelFunc()
-	err = cmd.RunJob(ctxWithTimeout, namespace, "pre-job", jobSpec.PreJob, jobSpec.Image, jobSpec.SecretNames, "")
-	if err != nil {
-		return err
+	return nil
+}
+
+// record permit to add the hook result on report, and call onSuccess when the hook must not be run again
+func (r *hookRunner) record(namespace string, hook string, status string, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.report.job(namespace, hook, status, err)
+	if r.onSuccess != nil && (status == jobStatusSuccess || status == jobStatusFailedIgnored) {
+		return r.onSuccess(namespace, hook)
 	}
 
 	return nil
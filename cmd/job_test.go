@@ -2,9 +2,19 @@ package cmd
 
 import (
 	"context"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
@@ -13,7 +23,9 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	discoveryfake "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 	k8stesting "k8s.io/client-go/testing"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
 func (s *TestSuite) TestRunPreJob() {
@@ -69,6 +81,18 @@ func (s *TestSuite) TestRunPreJob() {
 		return true, nil, nil
 	})
 
+	// Mock get secret
+	fakeClient.Fake.AddReactor("get", "secrets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		secret := &v1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-secret",
+				Namespace: "fake-namespace",
+			},
+		}
+
+		return true, secret, nil
+	})
+
 	// Mock get jobs
 	// First time for old job, and next that job is finished
 	countCallJob := 0
@@ -112,7 +136,7 @@ func (s *TestSuite) TestRunPreJob() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := runPreJob(context.Background(), cmd, "fake-namespace")
+	err := runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
 	assert.NoError(s.T(), err)
 }
 
@@ -169,6 +193,18 @@ func (s *TestSuite) TestRunPostJob() {
 		return true, nil, nil
 	})
 
+	// Mock get secret
+	fakeClient.Fake.AddReactor("get", "secrets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		secret := &v1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-secret",
+				Namespace: "fake-namespace",
+			},
+		}
+
+		return true, secret, nil
+	})
+
 	// Mock get jobs
 	// First time for old job, and next that job is finished
 	countCallJob := 0
@@ -212,6 +248,1396 @@ func (s *TestSuite) TestRunPostJob() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := runPostJob(context.Background(), cmd, "fake-namespace")
+	err := runPostJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+}
+
+// When some namespace jobs failed
+// It must run all jobs and return the combined errors
+func (s *TestSuite) TestRunNamespaceJobsWhenSomeFailed() {
+	fakeClient := fake.NewSimpleClientset(&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}})
+
+	// The job finish as soon as it created, it failed on namespace fake-namespace2
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		conditionType := batch.JobComplete
+		if action.GetNamespace() == "fake-namespace2" {
+			conditionType = batch.JobFailed
+		}
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   conditionType,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	hook := kubetool.Hook{Name: "pre-job", Phase: kubetool.HookPhasePre, Script: "fake pre-job", FailurePolicy: kubetool.HookFailurePolicyAbort}
+	jobs := []namespaceJob{
+		{Namespace: "fake-namespace1", Hooks: []kubetool.Hook{hook}},
+		{Namespace: "fake-namespace2", Hooks: []kubetool.Hook{hook}},
+		{Namespace: "fake-namespace3", Hooks: []kubetool.Hook{hook}},
+	}
+	report := newDowntimeReport("fake-node", "set-downtime")
+	succeeded := make([]string, 0)
+
+	runner := &hookRunner{
+		cmd:         cmd,
+		hookRun:     kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"},
+		phase:       kubetool.HookPhasePre,
+		concurrency: 2,
+		report:      report,
+		onSuccess: func(namespace string, hook string) error {
+			succeeded = append(succeeded, namespace)
+			return nil
+		},
+	}
+	err := runner.run(context.Background(), [][]namespaceJob{jobs})
+	assert.Error(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "fake-namespace2")
+	assert.ElementsMatch(s.T(), []string{"fake-namespace1", "fake-namespace3"}, succeeded)
+	assert.Len(s.T(), report.Jobs, 3)
+}
+
+// When jobs have order and dependencies
+// It must group them in waves, and reverse the waves for post-jobs
+func (s *TestSuite) TestJobWaves() {
+	jobs := []namespaceJob{
+		{Namespace: "database", Order: 2},
+		{Namespace: "kafka", Order: 1},
+		{Namespace: "app"},
+		{Namespace: "other"},
+		{Namespace: "monitoring", After: []string{"database", "not-on-node"}},
+	}
+
+	waves, err := jobWaves(jobs, false)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), [][]string{{"app", "other"}, {"kafka"}, {"database"}, {"monitoring"}}, waveNamespaces(waves))
+
+	waves, err = jobWaves(jobs, true)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), [][]string{{"monitoring"}, {"database"}, {"kafka"}, {"app", "other"}}, waveNamespaces(waves))
+
+	// When dependency is on job with higher order, the dependency win
+	jobs = []namespaceJob{
+		{Namespace: "app", After: []string{"kafka"}},
+		{Namespace: "kafka", Order: 1},
+	}
+	waves, err = jobWaves(jobs, false)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), [][]string{{"kafka"}, {"app"}}, waveNamespaces(waves))
+}
+
+// When dependencies form a cycle
+// It must return error
+func (s *TestSuite) TestJobWavesWhenCycle() {
+	jobs := []namespaceJob{
+		{Namespace: "app", After: []string{"database"}},
+		{Namespace: "kafka", After: []string{"app"}},
+		{Namespace: "database", After: []string{"kafka"}},
+		{Namespace: "other"},
+	}
+
+	_, err := jobWaves(jobs, false)
+	assert.Error(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "app, database, kafka")
+}
+
+func waveNamespaces(waves [][]namespaceJob) [][]string {
+	namespaces := make([][]string, 0, len(waves))
+	for _, wave := range waves {
+		names := make([]string, 0, len(wave))
+		for _, job := range wave {
+			names = append(names, job.Namespace)
+		}
+		namespaces = append(namespaces, names)
+	}
+	return namespaces
+}
+
+// When namespace has several hooks
+// It must run them one after another, continue on hook with continue failure policy and stop on the other failed hook
+func (s *TestSuite) TestRunNamespaceHooks() {
+	fakeClient := fake.NewSimpleClientset(&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}})
+
+	// The job finish as soon as it created, the hooks named failed-* failed
+	created := make([]string, 0)
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created = append(created, job.Labels[kubetool.JobHookLabel])
+		conditionType := batch.JobComplete
+		if strings.HasPrefix(job.Name, "patchmanagement-failed-") {
+			conditionType = batch.JobFailed
+		}
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   conditionType,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	job := namespaceJob{
+		Namespace: "fake-namespace",
+		Hooks: []kubetool.Hook{
+			{Name: "done", Phase: kubetool.HookPhasePre, Script: "fake"},
+			{Name: "failed-continue", Phase: kubetool.HookPhasePre, Script: "fake", FailurePolicy: kubetool.HookFailurePolicyContinue},
+			{Name: "flush", Phase: kubetool.HookPhasePre, Script: "fake"},
+			{Name: "failed-abort", Phase: kubetool.HookPhasePre, Script: "fake", FailurePolicy: kubetool.HookFailurePolicyAbort},
+			{Name: "never-run", Phase: kubetool.HookPhasePre, Script: "fake"},
+		},
+	}
+	state := kubetool.NewDowntimeState()
+	state.AddPreHook("fake-namespace", "done")
+	report := newDowntimeReport("fake-node", "set-downtime")
+
+	runner := &hookRunner{
+		cmd:     cmd,
+		hookRun: kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"},
+		phase:   kubetool.HookPhasePre,
+		report:  report,
+		isDone:  state.HasPreHook,
+		onSuccess: func(namespace string, hook string) error {
+			state.AddPreHook(namespace, hook)
+			return nil
+		},
+	}
+	err := runner.runNamespace(context.Background(), job)
+	assert.Error(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "failed-abort")
+	assert.Equal(s.T(), []string{"failed-continue", "flush", "failed-abort"}, created)
+	assert.Equal(s.T(), []string{"fake-namespace/done", "fake-namespace/failed-continue", "fake-namespace/flush"}, state.PreJobs)
+	if assert.Len(s.T(), report.Jobs, 4) {
+		assert.Equal(s.T(), jobStatusAlreadyRun, report.Jobs[0].Status)
+		assert.Equal(s.T(), jobStatusFailedIgnored, report.Jobs[1].Status)
+		assert.Equal(s.T(), jobStatusSuccess, report.Jobs[2].Status)
+		assert.Equal(s.T(), jobStatusFailed, report.Jobs[3].Status)
+	}
+}
+
+// When configmap declare hooks
+// It must return the legacy and declared hooks with their defaults
+func (s *TestSuite) TestGetJobSpecWithHooks() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job": "fake pre-job",
+			"image":   "fake-image",
+			"secrets": "fake-secret",
+			"hooks": `
+- name: flush
+  phase: pre
+  script: fake flush
+  timeout: 10m
+  failurePolicy: continue
+- name: resume
+  phase: post
+  script: fake resume
+  image: other-image
+  secrets: []
+`,
+		},
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	jobSpec, err := cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.NoError(s.T(), err)
+	preHooks := jobSpec.HooksOf(kubetool.HookPhasePre)
+	if assert.Len(s.T(), preHooks, 2) {
+		assert.Equal(s.T(), kubetool.Hook{Name: "pre-job", Type: kubetool.HookTypeJob, Phase: kubetool.HookPhasePre, Script: "fake pre-job", Image: "fake-image", Secrets: []string{"fake-secret"}, FailurePolicy: kubetool.HookFailurePolicyAbort}, preHooks[0])
+		assert.Equal(s.T(), "flush", preHooks[1].Name)
+		assert.Equal(s.T(), 10*time.Minute, preHooks[1].Timeout.Duration)
+		assert.True(s.T(), preHooks[1].IsContinueOnFailure())
+	}
+	postHooks := jobSpec.HooksOf(kubetool.HookPhasePost)
+	if assert.Len(s.T(), postHooks, 1) {
+		assert.Equal(s.T(), "other-image", postHooks[0].Image)
+		assert.Empty(s.T(), postHooks[0].Secrets)
+	}
+
+	// Hook name must be unique
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	configMap.Data["hooks"] = "- {name: pre-job, phase: pre, script: fake}"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+
+	// Phase must be valid
+	configMap.Data["hooks"] = "- {name: flush, phase: during, script: fake}"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+}
+
+// When configmap has job-template
+// It must merge it with the Job created by hook
+func (s *TestSuite) TestRunPreJobWithJobTemplate() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job": "fake pre-job",
+			"job-template": `
+apiVersion: v1
+kind: PodTemplate
+template:
+  spec:
+    serviceAccountName: fake-sa
+    securityContext:
+      runAsNonRoot: true
+    tolerations:
+    - key: dedicated
+      operator: Exists
+    containers:
+    - resources:
+        limits:
+          cpu: "1"
+      securityContext:
+        allowPrivilegeEscalation: false
+`,
+		},
+	})
+
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	err := runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		assert.True(s.T(), strings.HasPrefix(created.Name, "patchmanagement-pre-job-"))
+		podSpec := created.Spec.Template.Spec
+		assert.Equal(s.T(), "fake-sa", podSpec.ServiceAccountName)
+		assert.True(s.T(), *podSpec.SecurityContext.RunAsNonRoot)
+		assert.Len(s.T(), podSpec.Tolerations, 1)
+		if assert.Len(s.T(), podSpec.Containers, 1) {
+			container := podSpec.Containers[0]
+			assert.Equal(s.T(), "pre-job", container.Name)
+			assert.Equal(s.T(), []string{"-c", "fake pre-job"}, container.Args)
+			assert.Equal(s.T(), "1", container.Resources.Limits.Cpu().String())
+			assert.Equal(s.T(), "512Mi", container.Resources.Limits.Memory().String())
+			assert.False(s.T(), *container.SecurityContext.AllowPrivilegeEscalation)
+		}
+	}
+
+	// Template that change the fields managed by kubetool
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	for _, template := range []string{
+		"kind: PodTemplate\ntemplate:\n  spec:\n    restartPolicy: OnFailure",
+		"kind: Job\nspec:\n  backoffLimit: 10",
+		"kind: Job\nspec:\n  podFailurePolicy:\n    rules: []",
+	} {
+		configMap.Data["job-template"] = template
+		_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+		assert.NoError(s.T(), err)
+		jobSpec, err := cmd.GetJobSpec(context.Background(), "fake-namespace")
+		assert.NoError(s.T(), err)
+		err = cmd.CheckHookTemplates(context.Background(), "fake-namespace", jobSpec.HooksOf(kubetool.HookPhasePre)[0], kubetool.HookRun{ID: "fake-run"})
+		assert.ErrorContains(s.T(), err, "of job-template can't be changed")
+		created = nil
+		err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+		assert.ErrorContains(s.T(), err, "of job-template can't be changed")
+		assert.Nil(s.T(), created)
+	}
+
+	// Invalid template
+	configMap.Data["job-template"] = "kind: Deployment"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+}
+
+func (s *TestSuite) TestRunPreJobWithVolumes() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job":           "fake pre-job",
+			"secret-volumes":    "es-certs:/etc/es/certs",
+			"configmap-volumes": "es-ca:/etc/es/ca",
+		},
+	}, &v1.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "es-certs",
+			Namespace: "fake-namespace",
+		},
+	})
+
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	// When configmap is missing, the Job is not created
+	err := runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.ErrorContains(s.T(), err, "ConfigMap es-ca used by hook pre-job not found")
+	assert.Nil(s.T(), created)
+
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "es-ca",
+			Namespace: "fake-namespace",
+		},
+	}, meta.CreateOptions{})
+	assert.NoError(s.T(), err)
+
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		podSpec := created.Spec.Template.Spec
+		if assert.Len(s.T(), podSpec.Volumes, 2) {
+			assert.Equal(s.T(), "es-certs", podSpec.Volumes[0].Secret.SecretName)
+			assert.Equal(s.T(), "es-ca", podSpec.Volumes[1].ConfigMap.Name)
+		}
+		assert.Equal(s.T(), []v1.VolumeMount{
+			{Name: "secret-0", MountPath: "/etc/es/certs", ReadOnly: true},
+			{Name: "configmap-0", MountPath: "/etc/es/ca", ReadOnly: true},
+		}, podSpec.Containers[0].VolumeMounts)
+	}
+
+	// Invalid mount path
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	configMap.Data["secret-volumes"] = "es-certs:certs"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+}
+
+func (s *TestSuite) TestRunPreJobWithHistory() {
+	finished := batch.JobStatus{
+		Conditions: []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		},
+	}
+	oldJob := func(name string, age time.Duration, status batch.JobStatus) *batch.Job {
+		return &batch.Job{
+			ObjectMeta: meta.ObjectMeta{
+				Name:              name,
+				Namespace:         "fake-namespace",
+				Labels:            map[string]string{kubetool.JobHookLabel: "pre-job"},
+				CreationTimestamp: meta.NewTime(time.Now().Add(-age)),
+			},
+			Status: status,
+		}
+	}
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+			},
+		},
+		oldJob("patchmanagement-pre-job-old", 3*time.Hour, finished),
+		oldJob("patchmanagement-pre-job-running", 2*time.Hour, batch.JobStatus{}),
+		oldJob("patchmanagement-pre-job-recent", time.Hour, finished),
+		// Not created by hook
+		&batch.Job{
+			ObjectMeta: meta.ObjectMeta{
+				Name:              "backup",
+				Namespace:         "fake-namespace",
+				CreationTimestamp: meta.NewTime(time.Now().Add(-4 * time.Hour)),
+			},
+			Status: finished,
+		},
+	)
+
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Status = finished
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	ttl := int32(3600)
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node", TTLSecondsAfterFinished: &ttl, History: 2}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		assert.True(s.T(), strings.HasPrefix(created.Name, "patchmanagement-pre-job-"))
+		assert.LessOrEqual(s.T(), len(created.Name), 63)
+		assert.Equal(s.T(), map[string]string{
+			kubetool.JobRunIDLabel: "fake-run",
+			kubetool.JobNodeLabel:  "fake-node",
+			kubetool.JobPhaseLabel: kubetool.HookPhasePre,
+			kubetool.JobHookLabel:  "pre-job",
+		}, created.Labels)
+		assert.Equal(s.T(), created.Labels, created.Spec.Template.Labels)
+		assert.Equal(s.T(), &ttl, created.Spec.TTLSecondsAfterFinished)
+	}
+
+	// Only the current job and the most recent old job are kept, the running job and the job not created by hook are never removed
+	jobs, err := fakeClient.BatchV1().Jobs("fake-namespace").List(context.Background(), meta.ListOptions{})
+	assert.NoError(s.T(), err)
+	names := make([]string, 0, len(jobs.Items))
+	for _, job := range jobs.Items {
+		names = append(names, job.Name)
+	}
+	assert.ElementsMatch(s.T(), []string{created.Name, "patchmanagement-pre-job-recent", "patchmanagement-pre-job-running", "backup"}, names)
+
+	// The name is unique by run
+	firstName := created.Name
+	run.ID = "other-run"
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.NotEqual(s.T(), firstName, created.Name)
+}
+
+// When the pod of hook can't run or the Job failed
+// It must fail fast with the reason, and the exit code and termination message of container
+func (s *TestSuite) TestRunPreJobWhenFailFast() {
+	testCases := map[string]struct {
+		objects  func(job *batch.Job) []runtime.Object
+		failed   bool
+		contains []string
+	}{
+		"image pull": {
+			objects: func(job *batch.Job) []runtime.Object {
+				pod := newJobPod(job)
+				pod.Status.ContainerStatuses = []v1.ContainerStatus{
+					{
+						Name: "pre-job",
+						State: v1.ContainerState{
+							Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image \"redhat/ubi8-minimall\""},
+						},
+					},
+				}
+				return []runtime.Object{pod}
+			},
+			contains: []string{"ImagePullBackOff", "redhat/ubi8-minimall"},
+		},
+		"missing key on secret": {
+			objects: func(job *batch.Job) []runtime.Object {
+				pod := newJobPod(job)
+				pod.Status.ContainerStatuses = []v1.ContainerStatus{
+					{
+						Name: "pre-job",
+						State: v1.ContainerState{
+							Waiting: &v1.ContainerStateWaiting{Reason: "CreateContainerConfigError", Message: "couldn't find key password in Secret"},
+						},
+					},
+				}
+				return []runtime.Object{pod}
+			},
+			contains: []string{"CreateContainerConfigError", "couldn't find key password"},
+		},
+		"unschedulable": {
+			objects: func(job *batch.Job) []runtime.Object {
+				pod := newJobPod(job)
+				pod.Status.Conditions = []v1.PodCondition{
+					{
+						Type:    v1.PodScheduled,
+						Status:  v1.ConditionFalse,
+						Reason:  v1.PodReasonUnschedulable,
+						Message: "0/3 nodes are available: 3 Insufficient memory.",
+					},
+				}
+				return []runtime.Object{pod}
+			},
+			contains: []string{"Unschedulable", "Insufficient memory"},
+		},
+		"quota exceeded": {
+			objects: func(job *batch.Job) []runtime.Object {
+				return []runtime.Object{
+					&v1.Event{
+						ObjectMeta: meta.ObjectMeta{
+							Name:      job.Name + ".1",
+							Namespace: job.Namespace,
+						},
+						InvolvedObject: v1.ObjectReference{
+							Kind:      "Job",
+							Name:      job.Name,
+							Namespace: job.Namespace,
+						},
+						Reason:  "FailedCreate",
+						Message: "Error creating: pods \"" + job.Name + "-x\" is forbidden: exceeded quota: compute, requested: limits.memory=512Mi",
+					},
+				}
+			},
+			contains: []string{"FailedCreate", "exceeded quota"},
+		},
+		"job failed": {
+			objects: func(job *batch.Job) []runtime.Object {
+				pod := newJobPod(job)
+				pod.Status.ContainerStatuses = []v1.ContainerStatus{
+					{
+						Name: "pre-job",
+						State: v1.ContainerState{
+							Terminated: &v1.ContainerStateTerminated{ExitCode: 3, Reason: "Error", Message: "cluster is red"},
+						},
+					},
+				}
+				return []runtime.Object{pod}
+			},
+			failed:   true,
+			contains: []string{"BackoffLimitExceeded", "exited with code 3", "cluster is red"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+			},
+		})
+		fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+			job.Namespace = action.GetNamespace()
+			if testCase.failed {
+				job.Status.Conditions = []batch.JobCondition{
+					{
+						Type:   batch.JobFailed,
+						Status: v1.ConditionTrue,
+						Reason: "BackoffLimitExceeded",
+					},
+				}
+			}
+			for _, o := range testCase.objects(job) {
+				if err := fakeClient.Tracker().Add(o); err != nil {
+					return true, nil, err
+				}
+			}
+			return false, nil, nil
+		})
+		cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+		err := runPreJob(ctx, cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+		cancelFunc()
+		if assert.Error(s.T(), err, name) {
+			assert.True(s.T(), kubetool.IsErrHookFailed(err), name)
+			for _, expected := range testCase.contains {
+				assert.Contains(s.T(), err.Error(), expected, name)
+			}
+		}
+	}
+}
+
+// When the Job is completed after it's created
+// It must be notified by watch, without wait the resync period
+func (s *TestSuite) TestRunPreJobWhenCompletedLater() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job": "fake pre-job",
+		},
+	})
+	created := make(chan *batch.Job, 1)
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created <- action.(k8stesting.CreateAction).GetObject().(*batch.Job).DeepCopy()
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	go func() {
+		job := <-created
+		time.Sleep(500 * time.Millisecond)
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		_, err := fakeClient.BatchV1().Jobs("fake-namespace").UpdateStatus(context.Background(), job, meta.UpdateOptions{})
+		assert.NoError(s.T(), err)
+	}()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+	start := time.Now()
+	err := runPreJob(ctx, cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	assert.Less(s.T(), time.Since(start), 5*time.Second)
+}
+
+func newJobPod(job *batch.Job) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      job.Name + "-abcde",
+			Namespace: job.Namespace,
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+	}
+}
+
+// When the Job retried its pod
+// It must print the logs of each attempt, line by line with namespace, pod and attempt
+func (s *TestSuite) TestRunPreJobLogs() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job": "fake pre-job",
+		},
+	})
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		job.Namespace = action.GetNamespace()
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+
+		// The second attempt succeeded
+		for i, phase := range []v1.PodPhase{v1.PodSucceeded, v1.PodFailed} {
+			pod := newJobPod(job)
+			pod.Name = fmt.Sprintf("%s-%d", job.Name, i)
+			pod.CreationTimestamp = meta.NewTime(time.Now().Add(-time.Duration(i) * time.Minute))
+			pod.Status.Phase = phase
+			if err := fakeClient.Tracker().Add(pod); err != nil {
+				return true, nil, err
+			}
+		}
+		// Not yet started
+		pod := newJobPod(job)
+		pod.Name = job.Name + "-pending"
+		pod.Status.Phase = v1.PodPending
+		if err := fakeClient.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	err := runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+
+	lines := make([]string, 0)
+	for _, entry := range hook.AllEntries() {
+		if strings.HasPrefix(entry.Message, "[fake-namespace/patchmanagement-pre-job-") {
+			lines = append(lines, entry.Message)
+		}
+	}
+	if assert.Len(s.T(), lines, 2) {
+		assert.Regexp(s.T(), `^\[fake-namespace/patchmanagement-pre-job-\w+-1#1\] fake logs$`, lines[0])
+		assert.Regexp(s.T(), `^\[fake-namespace/patchmanagement-pre-job-\w+-0#2\] fake logs$`, lines[1])
+	}
+}
+
+// When artifacts are enabled
+// It must store the logs, the Job, its pods and events on directory and on ConfigMap
+func (s *TestSuite) TestRunPreJobWithArtifacts() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+			},
+		},
+		&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}},
+	)
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Namespace = action.GetNamespace()
+		created.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		pod := newJobPod(created)
+		pod.Status.Phase = v1.PodSucceeded
+		event := &v1.Event{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      pod.Name + ".1",
+				Namespace: created.Namespace,
+			},
+			InvolvedObject: v1.ObjectReference{
+				Kind:      "Pod",
+				Name:      pod.Name,
+				Namespace: created.Namespace,
+			},
+			Reason: "Pulled",
+		}
+		for _, o := range []runtime.Object{pod, event} {
+			if err := fakeClient.Tracker().Add(o); err != nil && !errors.IsAlreadyExists(err) {
+				return true, nil, err
+			}
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	dir := s.T().TempDir()
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node", ArtifactsDir: dir, ArtifactsConfigMap: true}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+
+	path := filepath.Join(dir, "fake-run", "fake-node_fake-namespace_pre_pre-job")
+	expectedFiles := map[string]string{
+		".log":         "#1] fake logs\n",
+		"-job.yaml":    "kind: Job",
+		"-pods.yaml":   created.Name + "-abcde",
+		"-events.yaml": "reason: Pulled",
+	}
+	for suffix, expected := range expectedFiles {
+		content, err := os.ReadFile(path + suffix)
+		if assert.NoError(s.T(), err) {
+			assert.Contains(s.T(), string(content), expected)
+		}
+	}
+
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), created.Name+"-artifacts", meta.GetOptions{})
+	if assert.NoError(s.T(), err) {
+		assert.Contains(s.T(), configMap.Data["logs"], "fake logs")
+		assert.Contains(s.T(), configMap.Data["job.yaml"], "kind: Job")
+		assert.Equal(s.T(), "fake-run", configMap.Labels[kubetool.JobRunIDLabel])
+		if assert.Len(s.T(), configMap.OwnerReferences, 1) {
+			assert.Equal(s.T(), created.Name, configMap.OwnerReferences[0].Name)
+		}
+	}
+
+	// When the run is resumed, the logs of previous attempt are kept
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	content, err := os.ReadFile(path + ".log")
+	if assert.NoError(s.T(), err) {
+		assert.Equal(s.T(), 2, strings.Count(string(content), "fake logs"))
+	}
+}
+
+// When configmap set the timeouts of hooks
+// The timeout of hook is used first, then the timeout of its phase, then the timeout of namespace, then the timeout of run
+func (s *TestSuite) TestRunJobWithTimeouts() {
+	fakeClient := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "patchmanagement",
+			Namespace: "fake-namespace",
+		},
+		Data: map[string]string{
+			"pre-job":         "fake pre-job",
+			"post-job":        "fake post-job",
+			"timeout":         "2m",
+			"pre-job-timeout": "4h",
+			"hooks": `
+- name: flush
+  phase: pre
+  script: fake flush
+  timeout: 10m
+`,
+		},
+	})
+	deadlines := map[string]int64{}
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		deadlines[job.Labels[kubetool.JobHookLabel]] = *job.Spec.ActiveDeadlineSeconds
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	run := kubetool.HookRun{ID: "fake-run", Timeout: 90 * time.Second}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	err = runPostJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), map[string]int64{"pre-job": 4 * 3600, "flush": 600, "post-job": 120}, deadlines)
+
+	// Without timeout on configmap
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	delete(configMap.Data, "timeout")
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPostJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(90), deadlines["post-job"])
+	err = runPostJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), int64(kubetool.DefaultHookTimeout.Seconds()), deadlines["post-job"])
+
+	// Invalid timeout
+	configMap.Data["post-job-timeout"] = "one hour"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+}
+
+// When the hook run on node
+// It must give to the script the context of run, the node labels and the pods of namespace hosted on node
+func (s *TestSuite) TestRunPreJobWithContext() {
+	isController := true
+	fakeClient := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+			},
+		},
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Labels: map[string]string{
+					"topology.kubernetes.io/zone":           "zone-a",
+					"node-role.kubernetes.io/elasticsearch": "",
+					"kubernetes.io/hostname":                "fake-node",
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "es-1",
+				Namespace: "fake-namespace",
+				Labels:    map[string]string{"app": "es"},
+				OwnerReferences: []meta.OwnerReference{
+					{Kind: "StatefulSet", Name: "es", Controller: &isController},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+			Status: v1.PodStatus{
+				Phase: v1.PodRunning,
+				PodIP: "10.0.0.1",
+				Conditions: []v1.PodCondition{
+					{Type: v1.PodReady, Status: v1.ConditionTrue},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "es-2",
+				Namespace: "fake-namespace",
+			},
+			Spec: v1.PodSpec{NodeName: "other-node"},
+		},
+	)
+
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node", ClusterName: "fake-cluster", NodeLabels: kubetool.DefaultHookNodeLabels}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		env := map[string]string{}
+		for _, envVar := range created.Spec.Template.Spec.Containers[0].Env {
+			env[envVar.Name] = envVar.Value
+		}
+		assert.Equal(s.T(), map[string]string{
+			"NODE_NAME":             "fake-node",
+			"KUBETOOL_NAMESPACE":    "fake-namespace",
+			"KUBETOOL_PHASE":        kubetool.HookPhasePre,
+			"KUBETOOL_HOOK":         "pre-job",
+			"KUBETOOL_RUN_ID":       "fake-run",
+			"KUBETOOL_CLUSTER_NAME": "fake-cluster",
+			"KUBETOOL_NODE_LABELS":  `{"node-role.kubernetes.io/elasticsearch":"","topology.kubernetes.io/zone":"zone-a"}`,
+			"KUBETOOL_PODS":         `[{"name":"es-1","labels":{"app":"es"},"phase":"Running","ready":true,"ip":"10.0.0.1","ownerKind":"StatefulSet","ownerName":"es"}]`,
+		}, env)
+	}
+
+	// Without node, the node labels and pods are empty
+	created = nil
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		env := map[string]string{}
+		for _, envVar := range created.Spec.Template.Spec.Containers[0].Env {
+			env[envVar.Name] = envVar.Value
+		}
+		assert.Equal(s.T(), "", env["NODE_NAME"])
+		assert.Equal(s.T(), "{}", env["KUBETOOL_NODE_LABELS"])
+		assert.Equal(s.T(), "[]", env["KUBETOOL_PODS"])
+	}
+}
+
+// When the script of hook is template
+// It must render it with the node, its zone and the pods of namespace hosted on it, and fail before create the Job when it can't
+func (s *TestSuite) TestRunPreJobWithTemplate() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"template": "true",
+				"pre-job":  `exclude {{ .Node.Name }} on {{ .Zone }} during {{ .Phase }}:{{ range .Pods }} {{ .Name }}{{ end }} ({{ index .Node.Labels "kubernetes.io/hostname" }})`,
+			},
+		},
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Labels: map[string]string{
+					"topology.kubernetes.io/zone": "zone-a",
+					"kubernetes.io/hostname":      "fake-host",
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "es-1",
+				Namespace: "fake-namespace",
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+	)
+
+	var created *batch.Job
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobComplete,
+				Status: v1.ConditionTrue,
+			},
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		assert.Equal(s.T(), []string{"-c", "exclude fake-node on zone-a during pre: es-1 (fake-host)"}, created.Spec.Template.Spec.Containers[0].Args)
+	}
+
+	// When the key is missing, the Job is not created
+	created = nil
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	configMap.Data["pre-job"] = `echo {{ .Node.Labels.region }}`
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.ErrorContains(s.T(), err, "Error when render template of hook pre-job")
+	assert.Nil(s.T(), created)
+
+	// When the template is invalid, the job spec is invalid
+	configMap.Data["pre-job"] = `echo {{ .Node.Name `
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.ErrorContains(s.T(), err, "Invalid template on script of hook pre-job")
+
+	// Without template, the script is used as is
+	configMap.Data["template"] = "false"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	if assert.NotNil(s.T(), created) {
+		assert.Equal(s.T(), []string{"-c", "echo {{ .Node.Name "}, created.Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+// fakePodExecutor permit to record the commands run on pods, and return the given output and exit code
+type fakePodExecutor struct {
+	calls    []string
+	output   string
+	exitCode int
+}
+
+func (e *fakePodExecutor) Exec(ctx context.Context, namespace string, podName string, container string, command []string, stdout io.Writer, stderr io.Writer) error {
+	e.calls = append(e.calls, fmt.Sprintf("%s/%s/%s: %s", namespace, podName, container, strings.Join(command, " ")))
+	if e.exitCode != 0 {
+		_, _ = io.WriteString(stderr, e.output)
+		return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code %d", e.exitCode), Code: e.exitCode}
+	}
+	_, _ = io.WriteString(stdout, e.output)
+	return nil
+}
+
+// When the hook is exec type
+// It must run the command on the running pods hosted on node that match the selector, and treat the exit code like Job result
+func (s *TestSuite) TestRunPreJobExec() {
+	newPod := func(name string, nodeName string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      name,
+				Namespace: "fake-namespace",
+				Labels:    map[string]string{"app": "kafka"},
+			},
+			Spec: v1.PodSpec{
+				NodeName: nodeName,
+				Containers: []v1.Container{
+					{Name: "sidecar"},
+					{Name: "kafka"},
+				},
+			},
+			Status: v1.PodStatus{Phase: phase},
+		}
+	}
+	fakeClient := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"hooks": `
+- name: pause
+  type: exec
+  phase: pre
+  exec:
+    selector: app=kafka
+    container: kafka
+    command: [kafka-pause, --all]
+`,
+			},
+		},
+		&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}},
+		newPod("kafka-1", "fake-node", v1.PodRunning),
+		newPod("kafka-2", "other-node", v1.PodRunning),
+		newPod("kafka-3", "fake-node", v1.PodPending),
+	)
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, errors.NewBadRequest("exec hook must not create Job")
+	})
+	executor := &fakePodExecutor{output: "consumers paused\n"}
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	cmd.SetPodExecutor(executor)
+
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"fake-namespace/kafka-1/kafka: kafka-pause --all"}, executor.calls)
+	messages := make([]string, 0)
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(s.T(), messages, "[fake-namespace/kafka-1/kafka] consumers paused")
+
+	// When the command failed, the hook failed with the last line of output
+	executor.calls = nil
+	executor.output = "broker not available\n"
+	executor.exitCode = 1
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.ErrorContains(s.T(), err, "Exec on container kafka of pod fake-namespace/kafka-1 failed: exited with code 1: broker not available")
+	assert.True(s.T(), kubetool.IsErrHookFailed(err))
+
+	// When the command veto the downtime
+	executor.exitCode = kubetool.HookVetoExitCode
+	executor.output = "lag is too high"
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.ErrorContains(s.T(), err, "Pod fake-namespace/kafka-1 vetoed the downtime: lag is too high")
+	assert.True(s.T(), kubetool.IsErrHookVetoed(err))
+
+	// Without node, the command run on all running pods
+	executor.calls = nil
+	executor.exitCode = 0
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run"})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{
+		"fake-namespace/kafka-1/kafka: kafka-pause --all",
+		"fake-namespace/kafka-2/kafka: kafka-pause --all",
+	}, executor.calls)
+
+	// The selector must be provided
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	configMap.Data["hooks"] = "- {name: pause, type: exec, phase: pre, script: fake}"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.ErrorContains(s.T(), err, "Selector of exec hook pause must be provided")
+}
+
+// When run pre job with webhook hook
+// It must call the endpoint with the rendered body and the headers, without create Job
+func (s *TestSuite) TestRunPreJobWebhook() {
+	type request struct {
+		method string
+		path   string
+		token  string
+		body   string
+	}
+	requests := make([]request, 0)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, request{method: r.Method, path: r.URL.Path, token: r.Header.Get("Authorization"), body: string(body)})
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("maintenance refused\n"))
+	}))
+	defer server.Close()
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"hooks": fmt.Sprintf(`
+- name: pause
+  type: webhook
+  phase: pre
+  webhook:
+    url: %s/admin/pause
+    headers:
+      - name: Authorization
+        secretKeyRef: {name: fake-secret, key: token}
+    body: '{"node": "{{ .NodeName }}", "run": "{{ .RunID }}"}'
+`, server.URL),
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-secret",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string][]byte{"token": []byte("Bearer fake-token")},
+		},
+		&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}},
+	)
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, errors.NewBadRequest("webhook hook must not create Job")
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"}
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []request{
+		{method: http.MethodPost, path: "/admin/pause", token: "Bearer fake-token", body: `{"node": "fake-node", "run": "fake-run"}`},
+	}, requests)
+
+	// When the endpoint return unexpected status, the hook failed with the response body
+	status = http.StatusConflict
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.ErrorContains(s.T(), err, "Webhook of hook pause for fake-namespace failed: 409 Conflict: maintenance refused")
+	assert.True(s.T(), kubetool.IsErrHookFailed(err))
+
+	// The URL must be provided
+	configMap, err := fakeClient.CoreV1().ConfigMaps("fake-namespace").Get(context.Background(), "patchmanagement", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	configMap.Data["hooks"] = "- {name: pause, type: webhook, phase: pre, webhook: {method: POST}}"
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.ErrorContains(s.T(), err, "URL or service of webhook hook pause must be provided")
+
+	// With service, it call it through the API server proxy
+	configMap.Data["hooks"] = `
+- name: pause
+  type: webhook
+  phase: pre
+  webhook:
+    service: {name: my-app, port: 8080}
+    path: /admin/pause?wait=true
+`
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	_, err = cmd.GetJobSpec(context.Background(), "fake-namespace")
+	assert.Error(s.T(), err)
+	configMap.Data["hooks"] = `
+- name: pause
+  type: webhook
+  phase: pre
+  webhook:
+    service:
+      name: my-app
+      port: 8080
+      path: /admin/pause?wait=true
+`
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), configMap, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.ErrorContains(s.T(), err, "need connexion from kube config")
+	requests = requests[:0]
+	status = http.StatusOK
+	cmd.SetRestConfig(&rest.Config{Host: server.URL})
+	err = runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []request{
+		{method: http.MethodPost, path: "/api/v1/namespaces/fake-namespace/services/http:my-app:8080/proxy/admin/pause"},
+	}, requests)
+}
+
+// When run pre job with webhook hook on https URL
+// It must check the server certificate with the CA of secret
+func (s *TestSuite) TestRunPreJobWebhookTLS() {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	newConfigMap := func(tls string) *v1.ConfigMap {
+		return &v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"hooks": fmt.Sprintf(`
+- name: pause
+  type: webhook
+  phase: pre
+  webhook:
+    url: %s/admin/pause
+    tls: %s
+`, server.URL, tls),
+			},
+		}
+	}
+	fakeClient := fake.NewSimpleClientset(
+		newConfigMap("{caSecretRef: {name: fake-ca, key: ca.crt}}"),
+		&v1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-ca",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string][]byte{"ca.crt": ca},
+		},
+		&v1.Node{ObjectMeta: meta.ObjectMeta{Name: "fake-node"}},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	run := kubetool.HookRun{ID: "fake-run", NodeName: "fake-node"}
+
+	err := runPreJob(context.Background(), cmd, "fake-namespace", run)
+	assert.NoError(s.T(), err)
+
+	// Without CA, the server certificate is unknown
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), newConfigMap("{}"), meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "other-run", NodeName: "fake-node"})
+	assert.ErrorContains(s.T(), err, "RequestFailed")
+
+	// Unless the check is disabled
+	_, err = fakeClient.CoreV1().ConfigMaps("fake-namespace").Update(context.Background(), newConfigMap("{insecureSkipVerify: true}"), meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "other-run", NodeName: "fake-node"})
+	assert.NoError(s.T(), err)
+}
+
+// When the pre hook veto the downtime and the Job is not failed, because of the cluster not apply pod failure policy
+// It must suspend the Job, so it not retry the hook, and keep its pod for the artifacts until it's pruned
+func (s *TestSuite) TestRunPreJobWhenVetoedWithoutPodFailurePolicy() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+			},
+		},
+	)
+	var jobName string
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		job.Namespace = action.GetNamespace()
+		jobName = job.Name
+		pod := newJobPod(job)
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{
+				Name: "pre-job",
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: kubetool.HookVetoExitCode, Message: "cluster is yellow"},
+				},
+			},
+		}
+		if err := fakeClient.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	dir := s.T().TempDir()
+
+	err := runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "fake-run", NodeName: "fake-node", ArtifactsDir: dir, History: 1})
+	assert.True(s.T(), kubetool.IsErrHookVetoed(err))
+	job, err := fakeClient.BatchV1().Jobs("fake-namespace").Get(context.Background(), jobName, meta.GetOptions{})
 	assert.NoError(s.T(), err)
+	assert.True(s.T(), *job.Spec.Suspend)
+	content, err := os.ReadFile(filepath.Join(dir, "fake-run", "fake-node_fake-namespace_pre_pre-job-pods.yaml"))
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), string(content), "cluster is yellow")
+
+	// The suspended Job is removed by the next run
+	vetoedJobName := jobName
+	err = runPreJob(context.Background(), cmd, "fake-namespace", kubetool.HookRun{ID: "other-run", NodeName: "fake-node", History: 1})
+	assert.True(s.T(), kubetool.IsErrHookVetoed(err))
+	_, err = fakeClient.BatchV1().Jobs("fake-namespace").Get(context.Background(), vetoedJobName, meta.GetOptions{})
+	assert.True(s.T(), errors.IsNotFound(err))
 }
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// lockOptions is the options of the cluster wide lock
+type lockOptions struct {
+	Namespace  string
+	Holder     string
+	MaxHolders int
+}
+
+// ForceReleaseLock permit to release the lock when the runner crash
+func ForceReleaseLock(c *cli.Context) error {
+	cmd, err := newCmd(c)
+	if err != nil {
+		log.Errorf("Can't connect on kubernetes: %s", err.Error())
+		os.Exit(1)
+	}
+
+	ctx, cancelFunc := getContext(c)
+	if cancelFunc != nil {
+		defer cancelFunc()
+	}
+
+	nodes, err := forceReleaseLock(ctx, cmd, c.String("lock-namespace"), c.String("node-name"))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Locks released for nodes: %s", strings.Join(nodes, ", "))
+	return nil
+}
+
+func forceReleaseLock(ctx context.Context, cmd *kubetool.Kubetool, namespace string, nodeName string) (nodes []string, err error) {
+	return cmd.ForceReleaseLock(ctx, namespace, nodeName)
+}
+
+// getLockOptions permit to read lock options from flags
+// It return nil if lock is disabled
+func getLockOptions(c *cli.Context) *lockOptions {
+	if !c.Bool("lock") {
+		return nil
+	}
+
+	holder := c.String("lock-holder")
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Warnf("Can't get hostname: %s", err.Error())
+		}
+		holder = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	return &lockOptions{
+		Namespace:  c.String("lock-namespace"),
+		Holder:     holder,
+		MaxHolders: c.Int("lock-max-holders"),
+	}
+}
+
+// acquireLock permit to take the lock for node if lock is enabled
+func acquireLock(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts *lockOptions) (err error) {
+	if opts == nil {
+		return nil
+	}
+
+	return cmd.AcquireLock(ctx, opts.Namespace, opts.Holder, nodeName, opts.MaxHolders)
+}
+
+// releaseLock permit to release the lock hold by node if lock is enabled
+func releaseLock(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts *lockOptions) (err error) {
+	if opts == nil {
+		return nil
+	}
+
+	return cmd.ReleaseLock(ctx, opts.Namespace, nodeName)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func (s *TestSuite) TestLock() {
+	fakeClient := fake.NewSimpleClientset()
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	opts := &lockOptions{
+		Namespace:  "kube-system",
+		Holder:     "test",
+		MaxHolders: 1,
+	}
+
+	// Acquire lock
+	err := acquireLock(context.TODO(), cmd, "node1", opts)
+	assert.NoError(s.T(), err)
+
+	// Acquire again lock for the same node
+	err = acquireLock(context.TODO(), cmd, "node1", opts)
+	assert.NoError(s.T(), err)
+
+	// Acquire lock for other node
+	err = acquireLock(context.TODO(), cmd, "node2", opts)
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrLocked(err))
+
+	// Release lock and acquire it for other node
+	err = releaseLock(context.TODO(), cmd, "node1", opts)
+	assert.NoError(s.T(), err)
+	err = acquireLock(context.TODO(), cmd, "node2", opts)
+	assert.NoError(s.T(), err)
+
+	// With many holders
+	opts.MaxHolders = 2
+	err = acquireLock(context.TODO(), cmd, "node3", opts)
+	assert.NoError(s.T(), err)
+	err = acquireLock(context.TODO(), cmd, "node4", opts)
+	assert.True(s.T(), kubetool.IsErrLocked(err))
+
+	// With less holders than the leases, even if low slot is free
+	err = releaseLock(context.TODO(), cmd, "node2", opts)
+	assert.NoError(s.T(), err)
+	opts.MaxHolders = 1
+	err = acquireLock(context.TODO(), cmd, "node4", opts)
+	assert.True(s.T(), kubetool.IsErrLocked(err))
+	opts.MaxHolders = 2
+	err = acquireLock(context.TODO(), cmd, "node2", opts)
+	assert.NoError(s.T(), err)
+
+	// Force release
+	nodes, err := forceReleaseLock(context.TODO(), cmd, "kube-system", "node3")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"node3"}, nodes)
+	nodes, err = forceReleaseLock(context.TODO(), cmd, "kube-system", "")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []string{"node2"}, nodes)
+
+	// Lock disabled
+	err = acquireLock(context.TODO(), cmd, "node1", nil)
+	assert.NoError(s.T(), err)
+	err = releaseLock(context.TODO(), cmd, "node1", nil)
+	assert.NoError(s.T(), err)
+}
+
+// When other node hold the lock
+// It must return error without cordon node
+func (s *TestSuite) TestSetDowntimeWhenLocked() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	opts := downtimeOptions{
+		Lock: &lockOptions{
+			Namespace:  "kube-system",
+			Holder:     "test",
+			MaxHolders: 1,
+		},
+	}
+
+	err := cmd.AcquireLock(context.TODO(), "kube-system", "other", "other-node", 1)
+	assert.NoError(s.T(), err)
+
+	err = setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrLocked(err))
+	assert.Equal(s.T(), kubetool.ExitCodeLocked, rescueDowntime(context.TODO(), cmd, "fake-node", opts, err))
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), node.Spec.Unschedulable)
+
+	// The lock of other node is kept
+	err = setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.True(s.T(), kubetool.IsErrLocked(err))
+}
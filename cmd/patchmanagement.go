@@ -2,8 +2,8 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
-	"time"
 
 	"github.com/disaster37/kubetool/v1.28/kubetool"
 	log "github.com/sirupsen/logrus"
@@ -22,6 +22,7 @@ func SetDowntime(c *cli.Context) error {
 		log.Errorf("Can't connect on kubernetes: %s", err.Error())
 		os.Exit(1)
 	}
+	redirectOutputs(cmd, c.String("output"))
 
 	ctx, cancelFunc := getContext(c)
 	if cancelFunc != nil {
@@ -29,40 +30,98 @@ func SetDowntime(c *cli.Context) error {
 	}
 
 	nodeName := c.String("node-name")
-	retryOnDrainFailed := c.Bool("retry-on-drain-failed")
-	nbRetry := c.Int("number-retry")
+	drainOpts := getDrainOptions(c)
+	jobs := getHookRun(c)
+	opts := downtimeOptions{
+		RetryDrainOnFailed: c.Bool("retry-on-drain-failed"),
+		NbRetry:            c.Int("number-retry"),
+		JobConcurrency:     c.Int("job-concurrency"),
+		Jobs:               &jobs,
+		Drain:              &drainOpts,
+		Lock:               getLockOptions(c),
+	}
+
+	if c.Bool("dry-run") {
+		plan, err := planSetDowntime(ctx, cmd, nodeName, opts)
+		if err != nil {
+			return err
+		}
+		return plan.print(os.Stdout, c.String("output"))
+	}
 
-	err = setDowntime(ctx, cmd, nodeName, retryOnDrainFailed, nbRetry)
+	opts.Report = getDowntimeReport(c, "set-downtime")
+	exitCode := kubetool.ExitCodeOK
+	err = setDowntime(ctx, cmd, nodeName, opts)
 	if err != nil {
 		log.Error(err.Error())
+		exitCode = rescueDowntime(ctx, cmd, nodeName, opts, err)
+	}
+	opts.Report.finish(err, exitCode)
+	writeReport(c, opts.Report)
 
-		if kubetool.IsRescueUncordon(err) {
-			err = cmd.Uncordon(context.Background(), nodeName)
-			if err != nil {
-				// Rescue failed
-				log.Errorf("Error when try to uncordon node %s on rescue step", nodeName)
-				log.Error(err.Error())
-				os.Exit(2)
-			}
+	if exitCode != kubetool.ExitCodeOK {
+		os.Exit(exitCode)
+	}
 
-			log.Warningf("Node %s successfully uncordonned in rescue step", nodeName)
-			os.Exit(1)
-		} else if kubetool.IsRescuePostJob(err) {
-			err = unsetDowntime(ctx, cmd, nodeName)
-			if err != nil {
-				// Rescue failed
-				log.Errorf("Error when try to uncordon node %s and lauch post job on rescue step", nodeName)
-				log.Error(err.Error())
-				os.Exit(2)
-			}
+	return nil
+
+}
+
+// rescueDowntime permit to run the rescue step needed by the error returned by setDowntime
+// It return the exit code:
+// 1 or typed exit code (see kubetool.ExitCode): the node is uncordonned, we need to skip node
+// 2: the rescue failed, we need to stop patchmanagement because of node is broken
+func rescueDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts downtimeOptions, err error) (exitCode int) {
+	exitCode = kubetool.ExitCode(err, kubetool.ExitCodeSkip)
 
-			log.Warningf("Node %s successfully uncordonned and post job lauch in rescue step", nodeName)
-			os.Exit(1)
+	if kubetool.IsRescueUncordon(err) {
+		err = cmd.Uncordon(context.Background(), nodeName)
+		opts.Report.rescue("uncordon", err)
+		if err != nil {
+			// Rescue failed
+			log.Errorf("Error when try to uncordon node %s on rescue step", nodeName)
+			log.Error(err.Error())
+			return kubetool.ExitCodeStop
 		}
-	}
 
-	return nil
+		// The node is back, so the downtime is finished
+		err = cmd.ClearDowntimeState(context.Background(), nodeName)
+		opts.Report.rescue("clear-state", err)
+		if err != nil {
+			log.Errorf("Error when clean downtime state of node %s: %s", nodeName, err.Error())
+		}
+		err = releaseLock(context.Background(), cmd, nodeName, opts.Lock)
+		opts.Report.rescue("release-lock", err)
+		if err != nil {
+			log.Errorf("Error when release lock of node %s: %s", nodeName, err.Error())
+		}
+
+		log.Warningf("Node %s successfully uncordonned in rescue step", nodeName)
+		return exitCode
+	} else if kubetool.IsRescuePostJob(err) {
+		// Phases of unset downtime are not part of the report
+		rescueOpts := opts
+		rescueOpts.Report = nil
+		err = unsetDowntime(ctx, cmd, nodeName, rescueOpts)
+		opts.Report.rescue("unset-downtime", err)
+		if err != nil {
+			// Rescue failed
+			log.Errorf("Error when try to uncordon node %s and lauch post job on rescue step", nodeName)
+			log.Error(err.Error())
+			return kubetool.ExitCodeStop
+		}
+
+		log.Warningf("Node %s successfully uncordonned and post job lauch in rescue step", nodeName)
+		return exitCode
+	}
 
+	// Nothing change on node
+	err = releaseLock(context.Background(), cmd, nodeName, opts.Lock)
+	opts.Report.rescue("release-lock", err)
+	if err != nil {
+		log.Errorf("Error when release lock of node %s: %s", nodeName, err.Error())
+	}
+	return exitCode
 }
 
 // UnsetDowntime permit to lauch some step after enable node
@@ -72,6 +131,7 @@ func UnsetDowntime(c *cli.Context) error {
 		log.Errorf("Can't connect on kubernetes: %s", err.Error())
 		os.Exit(2)
 	}
+	redirectOutputs(cmd, c.String("output"))
 
 	ctx, cancelFunc := getContext(c)
 	if cancelFunc != nil {
@@ -79,155 +139,438 @@ func UnsetDowntime(c *cli.Context) error {
 	}
 
 	nodeName := c.String("node-name")
+	gates := getGateTimeouts(c)
+	jobs := getHookRun(c)
+	opts := downtimeOptions{
+		JobConcurrency: c.Int("job-concurrency"),
+		Jobs:           &jobs,
+		Gates:          &gates,
+		Lock:           getLockOptions(c),
+	}
 
-	err = unsetDowntime(ctx, cmd, nodeName)
+	if c.Bool("dry-run") {
+		plan, err := planUnsetDowntime(ctx, cmd, nodeName, opts)
+		if err != nil {
+			return err
+		}
+		return plan.print(os.Stdout, c.String("output"))
+	}
+
+	opts.Report = getDowntimeReport(c, "unset-downtime")
+	exitCode := kubetool.ExitCodeOK
+	err = unsetDowntime(ctx, cmd, nodeName, opts)
 	if err != nil {
-		return err
+		log.Error(err.Error())
+		exitCode = unsetDowntimeExitCode(ctx, cmd, nodeName, err)
+	}
+	opts.Report.finish(err, exitCode)
+	writeReport(c, opts.Report)
+
+	if exitCode != kubetool.ExitCodeOK {
+		os.Exit(exitCode)
 	}
 
 	return nil
 }
 
+// unsetDowntimeExitCode return the exit code when unsetDowntime failed
+// 2: the node is still cordoned, whatever the error. Else the code of error, 1 by default
+func unsetDowntimeExitCode(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, err error) (exitCode int) {
+	state, stateErr := cmd.GetDowntimeState(ctx, nodeName)
+	if stateErr != nil || !state.IsAfter(kubetool.DowntimePhaseUncordoned) {
+		return kubetool.ExitCodeStop
+	}
+
+	return kubetool.ExitCode(err, kubetool.ExitCodeSkip)
+}
+
+// downtimeOptions is the options used by set-downtime and unset-downtime
+type downtimeOptions struct {
+	RetryDrainOnFailed bool
+	NbRetry            int
+
+	// JobConcurrency is how many namespace jobs run at the same time. Lower than 1 run them one by one
+	JobConcurrency int
+
+	// Jobs is nil when use the default hook Job options: no TTL and keep all Jobs
+	Jobs *kubetool.HookRun
+
+	// Drain is nil when use the default drain options
+	Drain *kubetool.DrainOptions
+
+	// Gates is nil when use the default readiness gate timeouts
+	Gates *kubetool.GateTimeouts
+
+	// Lock is nil when the lock is disabled
+	Lock *lockOptions
+
+	// Report is nil when the report is disabled
+	Report *downtimeReport
+}
+
+func (o downtimeOptions) drainOptions() kubetool.DrainOptions {
+	if o.Drain == nil {
+		return kubetool.DefaultDrainOptions()
+	}
+	return *o.Drain
+}
+
+// hookRun return the hook Job options for the given run of node
+func (o downtimeOptions) hookRun(runID string, nodeName string) kubetool.HookRun {
+	run := kubetool.HookRun{}
+	if o.Jobs != nil {
+		run = *o.Jobs
+	}
+	run.ID = runID
+	run.NodeName = nodeName
+
+	return run
+}
+
+func (o downtimeOptions) gateTimeouts() kubetool.GateTimeouts {
+	if o.Gates == nil {
+		return kubetool.DefaultGateTimeouts()
+	}
+	return *o.Gates
+}
+
+// getGateTimeouts permit to read readiness gate timeouts from flags
+func getGateTimeouts(c *cli.Context) kubetool.GateTimeouts {
+	return kubetool.GateTimeouts{
+		NodeReady:     c.Duration("node-ready-timeout"),
+		DaemonSetPods: c.Duration("daemonset-ready-timeout"),
+		EvictedPods:   c.Duration("evicted-pods-ready-timeout"),
+		Workloads:     c.Duration("workloads-ready-timeout"),
+	}
+}
+
 // retry params permit to mitigeate when patch master node, the time the LB switch to another master node
-func setDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, retryDrainOnFailed bool, nbRetry int) (err error) {
-	// check the node status
-	isOk, err := cmd.NodeOk(ctx, nodeName)
+// The progress is stored on node annotation, so it resume from the last completed phase if it run again
+func setDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts downtimeOptions) (err error) {
+	// Take the cluster wide lock, so only allowed number of nodes can be on downtime at the same time
+	opts.Report.phase("lock")
+	if err = acquireLock(ctx, cmd, nodeName, opts.Lock); err != nil {
+		log.Errorf("Error when acquire lock for node %s", nodeName)
+		return err
+	}
+
+	// Read the current downtime state
+	state, err := cmd.GetDowntimeState(ctx, nodeName)
 	if err != nil {
-		log.Errorf("Error when check the node state for %s", nodeName)
+		log.Errorf("Error when read the downtime state of node %s", nodeName)
 		return err
 	}
-	if !isOk {
-		log.Errorf("Node %s is not on ready state", nodeName)
-		return kubetool.NewErrNodeNotReady(nodeName)
+	if state.Phase == kubetool.DowntimePhaseDrained {
+		log.Infof("Node %s is already on downtime, nothing to do", nodeName)
+		return nil
+	}
+	if state.IsDowntimePhase() {
+		log.Infof("Resume downtime of node %s from phase %s", nodeName, state.Phase)
+		opts.Report.resume(state)
+	} else {
+		state = kubetool.NewDowntimeState()
+	}
+	opts.Report.run(state.RunID)
+
+	// check the node status
+	if !state.IsAfter(kubetool.DowntimePhaseChecked) {
+		opts.Report.phase("check")
+		isOk, err := cmd.NodeOk(ctx, nodeName)
+		if err != nil {
+			log.Errorf("Error when check the node state for %s", nodeName)
+			return err
+		}
+		if !isOk {
+			log.Errorf("Node %s is not on ready state", nodeName)
+			return kubetool.NewErrNodeNotReady(nodeName)
+		}
+
+		state.Phase = kubetool.DowntimePhaseChecked
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s", nodeName)
+			return err
+		}
 	}
 
 	// Cordon node
-	err = cmd.Cordon(ctx, nodeName)
-	if err != nil {
-		log.Errorf("Error when cordon node %s", nodeName)
-		return kubetool.NewRescueUncordonError(err)
+	if !state.IsAfter(kubetool.DowntimePhaseCordoned) {
+		opts.Report.phase("cordon")
+		err = cmd.Cordon(ctx, nodeName)
+		if err != nil {
+			log.Errorf("Error when cordon node %s", nodeName)
+			return kubetool.NewRescueUncordonError(err)
+		}
+
+		state.Phase = kubetool.DowntimePhaseCordoned
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s", nodeName)
+			return kubetool.NewRescueUncordonError(err)
+		}
 	}
 
 	// List all namespace and lauch pre-job if needed
-	namespaces, err := cmd.NamespacesPodsOnNode(ctx, nodeName)
-	if err != nil {
-		log.Errorf("Error when get all namespace for node %s", nodeName)
-		return kubetool.NewRescueUncordonError(err)
-	}
-	for _, namespace := range namespaces {
-		jobSpec, err := cmd.GetJobSpec(ctx, namespace)
+	if !state.IsAfter(kubetool.DowntimePhasePreJobsDone) {
+		opts.Report.phase("check-drain")
+		namespaces, err := cmd.NamespacesPodsOnNode(ctx, nodeName)
 		if err != nil {
-			log.Errorf("Error when try to get pre-job script on %s", namespace)
+			log.Errorf("Error when get all namespace for node %s", nodeName)
 			return kubetool.NewRescueUncordonError(err)
 		}
-		if jobSpec != nil && jobSpec.PreJob != "" {
-			log.Infof("Pre script found on %s, running it...", namespace)
 
-			// Run job
-			ctxWithTimeout, cancelFun := context.WithTimeout(ctx, time.Minute*30)
-			defer cancelFun()
-			err = cmd.RunJob(ctxWithTimeout, namespace, "pre-job", jobSpec.PreJob, jobSpec.Image, jobSpec.SecretNames, nodeName)
+		// Check the drain will be not blocked by PodDisruptionBudget before run pre-jobs
+		if err = checkDrain(ctx, cmd, nodeName, opts.drainOptions()); err != nil {
+			log.Errorf("Node %s can't be drained", nodeName)
+			return kubetool.NewRescueUncordonError(err)
+		}
+
+		opts.Report.phase("pre-jobs")
+		jobs := make([]namespaceJob, 0, len(namespaces))
+		for _, namespace := range namespaces {
+			jobSpec, err := cmd.GetJobSpec(ctx, namespace)
 			if err != nil {
-				log.Errorf("Error when run pre-job for %s", namespace)
-				return kubetool.NewRescuePostJobError(err)
+				log.Errorf("Error when try to get pre-job script on %s", namespace)
+				return kubetool.NewRescueUncordonError(err)
 			}
+			if jobSpec != nil && len(jobSpec.HooksOf(kubetool.HookPhasePre)) > 0 {
+				log.Infof("Pre hooks found on %s", namespace)
+				jobs = append(jobs, newNamespaceJob(namespace, kubetool.HookPhasePre, jobSpec))
+			}
+		}
 
-			log.Infof("Run pre-job successfully for %s", namespace)
+		waves, err := jobWaves(jobs, false)
+		if err != nil {
+			log.Errorf("Error when compute the order of pre-jobs: %s", err.Error())
+			return kubetool.NewRescueUncordonError(err)
 		}
-	}
 
-	// Drain node
-	if retryDrainOnFailed {
-		currentRetry := 0
-		for currentRetry < nbRetry {
-			err = cmd.Drain(ctx, nodeName, 600*time.Second)
-			if err != nil {
-				log.Errorf("Error when drain node %s, retry in few seconds ...", nodeName)
-				time.Sleep(10 * time.Second)
-			} else {
-				break
+		// Run the hooks, the state is saved after each success so the hooks are not rerun on resume
+		runner := &hookRunner{
+			cmd:         cmd,
+			hookRun:     opts.hookRun(state.RunID, nodeName),
+			phase:       kubetool.HookPhasePre,
+			concurrency: opts.JobConcurrency,
+			report:      opts.Report,
+			isDone:      state.HasPreHook,
+			onSuccess: func(namespace string, hook string) error {
+				state.AddPreHook(namespace, hook)
+				if err := cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+					log.Errorf("Error when save downtime state of node %s", nodeName)
+					return err
+				}
+				return nil
+			},
+		}
+		if err = runner.checkTemplates(ctx, waves); err != nil {
+			log.Errorf("Error when render the pre-jobs scripts: %s", err.Error())
+			return kubetool.NewRescueUncordonError(err)
+		}
+		if err = runner.run(ctx, waves); err != nil {
+			// The veto is clean skip of node, except when other pre hooks already run and must be reverted by post-jobs
+			if kubetool.IsErrHookVetoed(err) && len(state.PreJobs) == 0 {
+				return kubetool.NewRescueUncordonError(err)
 			}
+			return kubetool.NewRescuePostJobError(err)
 		}
-		if err != nil {
-			log.Errorf("Error when drain node %s", nodeName)
+
+		state.Phase = kubetool.DowntimePhasePreJobsDone
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s", nodeName)
 			return kubetool.NewRescuePostJobError(err)
 		}
+	}
 
-	} else {
-		err = cmd.Drain(ctx, nodeName, 600*time.Second)
-		if err != nil {
-			log.Errorf("Error when drain node %s", nodeName)
+	// Record the pods evicted by drain and the workloads hosted on node, to check they are back when unset downtime
+	opts.Report.phase("drain")
+	pods, err := cmd.PodsForDeletion(ctx, nodeName, opts.drainOptions())
+	if err != nil {
+		log.Errorf("Error when compute pods to evict on node %s", nodeName)
+		return kubetool.NewRescuePostJobError(err)
+	}
+	opts.Report.evictedPods(pods)
+	evictedWorkloads, err := cmd.EvictedWorkloads(ctx, pods)
+	if err != nil {
+		log.Errorf("Error when compute pods to evict on node %s", nodeName)
+		return kubetool.NewRescuePostJobError(err)
+	}
+	workloads, err := cmd.SnapshotWorkloads(ctx, nodeName)
+	if err != nil {
+		log.Errorf("Error when compute workloads on node %s", nodeName)
+		return kubetool.NewRescuePostJobError(err)
+	}
+	if len(evictedWorkloads) > 0 || len(workloads) > 0 {
+		state.AddEvictedWorkloads(evictedWorkloads)
+		state.AddWorkloads(workloads)
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s", nodeName)
 			return kubetool.NewRescuePostJobError(err)
 		}
 	}
 
+	// Drain node
+	if opts.RetryDrainOnFailed {
+		retryPolicy := cmd.RetryPolicy()
+		retryPolicy.MaxAttempts = opts.NbRetry
+		err = retryPolicy.Do(ctx, fmt.Sprintf("drain node %s", nodeName), nil, func() error {
+			return cmd.Drain(ctx, nodeName, opts.drainOptions())
+		})
+	} else {
+		err = cmd.Drain(ctx, nodeName, opts.drainOptions())
+	}
+	if err != nil {
+		log.Errorf("Error when drain node %s", nodeName)
+		return kubetool.NewRescuePostJobError(err)
+	}
+
+	state.Phase = kubetool.DowntimePhaseDrained
+	if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+		log.Errorf("Error when save downtime state of node %s", nodeName)
+		return kubetool.NewRescuePostJobError(err)
+	}
+
 	log.Infof("Node %s is ready to be patched", nodeName)
 
 	return nil
 }
 
-func unsetDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string) (err error) {
+// The progress is stored on node annotation, so it resume from the last completed phase if it run again
+func unsetDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts downtimeOptions) (err error) {
 
-	// wait node to be ready
-	for {
-		isOk, err := cmd.NodeOk(ctx, nodeName)
-		if err != nil {
-			log.Errorf("Error when get state of node %s: %s", nodeName, err.Error())
+	// Read the current downtime state
+	state, err := cmd.GetDowntimeState(ctx, nodeName)
+	if err != nil {
+		log.Errorf("Error when read the downtime state of node %s: %s", nodeName, err.Error())
+		return err
+	}
+	if state.IsAfter(kubetool.DowntimePhaseUncordoned) {
+		log.Infof("Resume online of node %s from phase %s", nodeName, state.Phase)
+		opts.Report.resume(state)
+	}
+	opts.Report.run(state.RunID)
+	gates := opts.gateTimeouts()
+
+	if !state.IsAfter(kubetool.DowntimePhaseUncordoned) {
+		// wait node to be ready
+		opts.Report.phase("wait-node-ready")
+		if err = cmd.WaitNodeReady(ctx, nodeName, gates.NodeReady); err != nil {
+			log.Errorf("Error when wait node %s to be ready: %s", nodeName, err.Error())
 			return err
 		}
-		if !isOk {
-			log.Infof("Node %s is not on ready state, we wait ...", nodeName)
-			time.Sleep(10 * time.Second)
-		} else {
-			log.Debugf("Node %s is ready", nodeName)
-			break
+
+		// Uncordon the node
+		opts.Report.phase("uncordon")
+		err = cmd.Uncordon(ctx, nodeName)
+		if err != nil {
+			log.Errorf("Error when uncordon node %s: %s", nodeName, err.Error())
+			return err
 		}
-	}
 
-	// Uncordon the node
-	err = cmd.Uncordon(ctx, nodeName)
-	if err != nil {
-		log.Errorf("Error when uncordon node %s: %s", nodeName, err.Error())
-		return err
+		state.Phase = kubetool.DowntimePhaseUncordoned
+		state.PostJobs = make([]string, 0)
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s: %s", nodeName, err.Error())
+			return err
+		}
 	}
 
-	// Sleep and wait pods
-	time.Sleep(30 * time.Second)
-
 	// List all namespace and lauch post-job if needed
-	namespaces, err := cmd.NamespacesPodsOnNode(ctx, nodeName)
-	if err != nil {
-		log.Errorf("Error when get all namespace for node %s: %s", nodeName, err.Error())
-		return err
-	}
-	for _, namespace := range namespaces {
-		jobSpec, err := cmd.GetJobSpec(ctx, namespace)
-		if err != nil {
-			log.Errorf("Error when try to get post-job script on %s: %s", namespace, err.Error())
+	if !state.IsAfter(kubetool.DowntimePhasePostJobsDone) {
+		// Wait pods are back before run post-jobs
+		opts.Report.phase("wait-daemonset-pods")
+		if err = cmd.WaitDaemonSetPodsReady(ctx, nodeName, gates.DaemonSetPods); err != nil {
+			log.Errorf("Error when wait DaemonSet pods on node %s: %s", nodeName, err.Error())
+			return err
+		}
+		opts.Report.phase("wait-evicted-pods")
+		if err = cmd.WaitEvictedWorkloadsReady(ctx, nodeName, state.EvictedWorkloads, gates.EvictedPods); err != nil {
+			log.Errorf("Error when wait evicted pods of node %s to be rescheduled: %s", nodeName, err.Error())
 			return err
 		}
-		if jobSpec != nil && jobSpec.PostJob != "" {
-			log.Infof("Post script found on %s, running it...", namespace)
 
-			// Run job
-			ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, time.Minute*30)
-			defer cancelFunc()
-			err = cmd.RunJob(ctxWithTimeout, namespace, "post-job", jobSpec.PostJob, jobSpec.Image, jobSpec.SecretNames, nodeName)
+		opts.Report.phase("post-jobs")
+		namespaces, err := cmd.NamespacesPodsOnNode(ctx, nodeName)
+		if err != nil {
+			log.Errorf("Error when get all namespace for node %s: %s", nodeName, err.Error())
+			return err
+		}
+		jobs := make([]namespaceJob, 0, len(namespaces))
+		for _, namespace := range namespaces {
+			jobSpec, err := cmd.GetJobSpec(ctx, namespace)
 			if err != nil {
-				log.Errorf("Error when run post-job for %s: %s", namespace, err.Error())
+				log.Errorf("Error when try to get post-job script on %s: %s", namespace, err.Error())
 				return err
 			}
+			if jobSpec != nil && len(jobSpec.HooksOf(kubetool.HookPhasePost)) > 0 {
+				log.Infof("Post hooks found on %s", namespace)
+				jobs = append(jobs, newNamespaceJob(namespace, kubetool.HookPhasePost, jobSpec))
+			}
+		}
+
+		// The post-jobs run in reverse order of pre-jobs
+		waves, err := jobWaves(jobs, true)
+		if err != nil {
+			log.Errorf("Error when compute the order of post-jobs: %s", err.Error())
+			return err
+		}
 
-			log.Infof("Run post-job successfully for %s", namespace)
+		// Run the hooks, the state is saved after each success so the hooks are not rerun on resume
+		runner := &hookRunner{
+			cmd:         cmd,
+			hookRun:     opts.hookRun(state.RunID, nodeName),
+			phase:       kubetool.HookPhasePost,
+			concurrency: opts.JobConcurrency,
+			report:      opts.Report,
+			isDone:      state.HasPostHook,
+			onSuccess: func(namespace string, hook string) error {
+				state.AddPostHook(namespace, hook)
+				if err := cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+					log.Errorf("Error when save downtime state of node %s: %s", nodeName, err.Error())
+					return err
+				}
+				return nil
+			},
+		}
+		if err = runner.checkTemplates(ctx, waves); err != nil {
+			log.Errorf("Error when render the post-jobs scripts: %s", err.Error())
+			return err
+		}
+		if err = runner.run(ctx, waves); err != nil {
+			return err
+		}
+
+		state.Phase = kubetool.DowntimePhasePostJobsDone
+		if err = cmd.SetDowntimeState(ctx, nodeName, state); err != nil {
+			log.Errorf("Error when save downtime state of node %s: %s", nodeName, err.Error())
+			return err
 		}
 	}
 
+	opts.Report.phase("wait-pods")
 	err = cmd.WaitPodsOnNode(ctx, nodeName)
 	if err != nil {
 		log.Errorf("Error when wait pods to be started on node %s: %s", nodeName, err.Error())
 		return err
 	}
 
+	// Check the workloads that had pods on node are back
+	opts.Report.phase("wait-workloads")
+	if err = cmd.WaitWorkloadsReady(ctx, nodeName, state.Workloads, gates.Workloads); err != nil {
+		log.Errorf("Error when wait workloads of node %s to be ready: %s", nodeName, err.Error())
+		return err
+	}
+
+	// The maintenance is finished
+	opts.Report.phase("clear-state")
+	if err = cmd.ClearDowntimeState(ctx, nodeName); err != nil {
+		log.Errorf("Error when clean downtime state of node %s: %s", nodeName, err.Error())
+		return err
+	}
+
+	// Let other nodes to be on downtime
+	if err = releaseLock(ctx, cmd, nodeName, opts.Lock); err != nil {
+		log.Errorf("Error when release lock of node %s: %s", nodeName, err.Error())
+		return err
+	}
+
 	log.Infof("Node %s successfully cordonned", nodeName)
 
 	return nil
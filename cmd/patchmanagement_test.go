@@ -3,18 +3,22 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/disaster37/kubetool/v1.28/kubetool"
 	"github.com/stretchr/testify/assert"
+	apps "k8s.io/api/apps/v1"
 	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
 )
 
 // When node is not ready
@@ -43,7 +47,7 @@ func (s *TestSuite) TestSetDowntimeWhenNodeNotReady() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.Error(s.T(), err, "Node fake-node is not on ready state")
 }
 
@@ -86,7 +90,7 @@ func (s *TestSuite) TestSetDowntimeWhenCordonFailed() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.Error(s.T(), err, "Cordon failed")
 
 }
@@ -130,7 +134,7 @@ func (s *TestSuite) TestSetDowntimeWhenCordonFailedWithRetry() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := setDowntime(context.TODO(), cmd, "fake-node", true, 1)
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{RetryDrainOnFailed: true, NbRetry: 1})
 	assert.Error(s.T(), err, "Cordon failed")
 
 }
@@ -201,7 +205,7 @@ func (s *TestSuite) TestSetDowntimeWhenNoPodsAndDrainSuccess() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.NoError(s.T(), err)
 
 }
@@ -280,6 +284,11 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndDrainSuccess() {
 		return true, pods, nil
 	})
 
+	// Mock list pdb
+	fakeClient.AddReactor("list", "poddisruptionbudgets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &policy.PodDisruptionBudgetList{}, nil
+	})
+
 	// Mock get pod
 	fakeClient.AddReactor("get", "pods", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
 
@@ -302,9 +311,9 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndDrainSuccess() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	_ = setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	_ = setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	// No more working
-	//err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	//err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	//assert.NoError(s.T(), err)
 
 }
@@ -386,6 +395,11 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndDrainFailed() {
 		return true, pods, nil
 	})
 
+	// Mock list pdb
+	fakeClient.Fake.AddReactor("list", "poddisruptionbudgets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &policy.PodDisruptionBudgetList{}, nil
+	})
+
 	// Mock get pod
 	fakeClient.Fake.AddReactor("get", "pods", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
 
@@ -409,7 +423,7 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndDrainFailed() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.Error(s.T(), err, "Failed to delete pod")
 
 }
@@ -482,6 +496,11 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndPrejobWitSecretAndDrainSuccess() {
 		return true, pods, nil
 	})
 
+	// Mock list pdb
+	fakeClient.Fake.AddReactor("list", "poddisruptionbudgets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, &policy.PodDisruptionBudgetList{}, nil
+	})
+
 	// Mock get pod
 	fakeClient.Fake.AddReactor("get", "pods", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
 
@@ -510,6 +529,18 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndPrejobWitSecretAndDrainSuccess() {
 		return true, nil, nil
 	})
 
+	// Mock get secret
+	fakeClient.Fake.AddReactor("get", "secrets", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		secret := &v1.Secret{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-secret",
+				Namespace: "fake-namespace",
+			},
+		}
+
+		return true, secret, nil
+	})
+
 	// Mock get jobs
 	// First time for old job, and next that job is finished
 	countCallJob := 0
@@ -553,9 +584,9 @@ func (s *TestSuite) TestSetDowntimeWhenPodsAndPrejobWitSecretAndDrainSuccess() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	_ = setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	_ = setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	//no more working
-	//err := setDowntime(context.TODO(), cmd, "fake-node", false, 0)
+	//err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	//assert.NoError(s.T(), err)
 }
 
@@ -598,7 +629,7 @@ func (s *TestSuite) TestUnsetDowntimeWhenUncordonFailed() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := unsetDowntime(context.TODO(), cmd, "fake-node")
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.Error(s.T(), err, "Uncordon failed")
 }
 
@@ -664,7 +695,7 @@ func (s *TestSuite) TestUnsetDowntimeWhenNoPodsAndUncordonSuccess() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := unsetDowntime(context.TODO(), cmd, "fake-node")
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.NoError(s.T(), err)
 
 }
@@ -763,7 +794,7 @@ func (s *TestSuite) TestUnsetDowntimeWhenPodsAndUncordonSuccess() {
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := unsetDowntime(context.TODO(), cmd, "fake-node")
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
 	assert.NoError(s.T(), err)
 
 }
@@ -902,6 +933,675 @@ func (s *TestSuite) TestUnsetDowntimeWhenPodsAndPostjobWitSecretAndUncordonSucce
 	})
 	cmd := kubetool.NewConnexionFromClient(fakeClient)
 
-	err := unsetDowntime(context.TODO(), cmd, "fake-node")
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+}
+
+// When node is already drained from previous run
+// It must do nothing
+func (s *TestSuite) TestSetDowntimeWhenAlreadyDrained() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"drained"}`,
+				},
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), node.Spec.Unschedulable)
+}
+
+// When node is already cordoned from previous run
+// It must resume without check node state and store drained phase
+func (s *TestSuite) TestSetDowntimeWhenResumeFromCordoned() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"cordoned"}`,
+				},
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+
+	state, err := cmd.GetDowntimeState(context.TODO(), "fake-node")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), kubetool.DowntimePhaseDrained, state.Phase)
+}
+
+// When post jobs already run from previous run
+// It must skip uncordon and clean the downtime state
+func (s *TestSuite) TestUnsetDowntimeWhenResumeFromPostJobsDone() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"post-jobs-done"}`,
+				},
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), node.Spec.Unschedulable)
+	assert.NotContains(s.T(), node.Annotations, kubetool.DowntimeStateAnnotation)
+}
+
+// When cordon failed with transient error
+// It must retry and succeed
+func (s *TestSuite) TestSetDowntimeWhenCordonTransientError() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+	)
+
+	// Mock cordon node failed the first time
+	nbPatch := 0
+	fakeClient.PrependReactor("patch", "nodes", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		patch := string(action.(k8stesting.PatchAction).GetPatch())
+		if !strings.Contains(patch, "unschedulable") {
+			return false, nil, nil
+		}
+		nbPatch++
+		if nbPatch == 1 {
+			return true, nil, errors.NewServiceUnavailable("fake error")
+		}
+		return false, nil, nil
+	})
+
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	cmd.SetRetryPolicy(kubetool.RetryPolicy{MaxAttempts: 3, InitialDelay: 1 * time.Millisecond})
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, nbPatch)
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), node.Spec.Unschedulable)
+}
+
+// When drain always failed with retry
+// It must stop after number of retry and return retry exhausted error
+func (s *TestSuite) TestSetDowntimeWhenDrainFailedWithRetry() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+	)
+
+	// Mock delete pod
+	nbDelete := 0
+	fakeClient.PrependReactor("delete", "pods", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		nbDelete++
+		return true, nil, fmt.Errorf("Failed to delete pod")
+	})
+
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	cmd.SetRetryPolicy(kubetool.RetryPolicy{MaxAttempts: 5, InitialDelay: 1 * time.Millisecond})
+	drainOpts := kubetool.DefaultDrainOptions()
+	drainOpts.Force = false
+	drainOpts.DisableEviction = true
+	drainOpts.Timeout = 5 * time.Second
+
+	// Unmanaged pod is blocked without force, drain failed before delete
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{RetryDrainOnFailed: true, NbRetry: 2, Drain: &drainOpts})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsRescuePostJob(err))
+	assert.True(s.T(), kubetool.IsErrRetryExhausted(err))
+	assert.Equal(s.T(), 0, nbDelete)
+
+	// Force delete failed on each attempt
+	drainOpts.Force = true
+	err = setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{RetryDrainOnFailed: true, NbRetry: 3, Drain: &drainOpts})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrRetryExhausted(err))
+	assert.Equal(s.T(), 3, nbDelete)
+}
+
+// When node become ready after some time
+// It must wait the node ready gate and uncordon it
+func (s *TestSuite) TestUnsetDowntimeWhenNodeBecomeReady() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"drained"}`,
+				},
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	// Node not ready until timeout
+	gates := kubetool.GateTimeouts{NodeReady: 100 * time.Millisecond}
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrGateFailed(err))
+	assert.Contains(s.T(), err.Error(), kubetool.GateNodeReady)
+
+	// Node become ready
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		node, _ := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+		node.Status.Conditions = []v1.NodeCondition{
+			{
+				Type:   v1.NodeReady,
+				Status: v1.ConditionTrue,
+			},
+		}
+		_, _ = fakeClient.CoreV1().Nodes().UpdateStatus(context.TODO(), node, meta.UpdateOptions{})
+	}()
+	gates = kubetool.GateTimeouts{NodeReady: 10 * time.Second}
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.NoError(s.T(), err)
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.False(s.T(), node.Spec.Unschedulable)
+	assert.NotContains(s.T(), node.Annotations, kubetool.DowntimeStateAnnotation)
+}
+
+// When readiness gate failed
+// It must return the stop exit code while node is cordoned, else the code of gate failure
+func (s *TestSuite) TestUnsetDowntimeExitCode() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"drained"}`,
+				},
+			},
+			Spec: v1.NodeSpec{Unschedulable: true},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-ds-abcd",
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "DaemonSet",
+						Name:       "fake-ds",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	// Node not ready, so it's still cordoned
+	gates := kubetool.GateTimeouts{NodeReady: 100 * time.Millisecond}
+	err := unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.True(s.T(), kubetool.IsErrGateFailed(err))
+	assert.Equal(s.T(), kubetool.ExitCodeStop, unsetDowntimeExitCode(context.TODO(), cmd, "fake-node", err))
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), node.Spec.Unschedulable)
+
+	// Node ready but DaemonSet pod not ready after uncordon
+	node.Status.Conditions = []v1.NodeCondition{
+		{
+			Type:   v1.NodeReady,
+			Status: v1.ConditionTrue,
+		},
+	}
+	_, err = fakeClient.CoreV1().Nodes().UpdateStatus(context.TODO(), node, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	gates = kubetool.GateTimeouts{NodeReady: 10 * time.Second, DaemonSetPods: 100 * time.Millisecond}
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.True(s.T(), kubetool.IsErrGateFailed(err))
+	assert.Equal(s.T(), kubetool.ExitCodeGateFailed, unsetDowntimeExitCode(context.TODO(), cmd, "fake-node", err))
+}
+
+// When pods are evicted by drain
+// It must record them and wait they are rescheduled before run post jobs
+func (s *TestSuite) TestUnsetDowntimeWhenEvictedPodsRescheduled() {
+
+	newPod := func(name string, nodeName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      name,
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "ReplicaSet",
+						Name:       "fake-rs",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: nodeName},
+			Status: v1.PodStatus{
+				Conditions: []v1.PodCondition{
+					{
+						Type:   v1.PodReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		}
+	}
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		newPod("fake-pod", "fake-node"),
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	drainOpts := kubetool.DefaultDrainOptions()
+	drainOpts.DisableEviction = true
+	drainOpts.Timeout = 10 * time.Second
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Drain: &drainOpts})
+	assert.NoError(s.T(), err)
+
+	state, err := cmd.GetDowntimeState(context.TODO(), "fake-node")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []kubetool.EvictedWorkload{
+		{
+			Namespace: "fake-namespace",
+			Kind:      "ReplicaSet",
+			Name:      "fake-rs",
+			ReadyPods: 1,
+		},
+	}, state.EvictedWorkloads)
+
+	// Pod not yet rescheduled
+	gates := kubetool.DefaultGateTimeouts()
+	gates.EvictedPods = 100 * time.Millisecond
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrGateFailed(err))
+	assert.Contains(s.T(), err.Error(), "ReplicaSet fake-namespace/fake-rs has 0/1 ready pods")
+
+	// Pod rescheduled on other node
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = fakeClient.CoreV1().Pods("fake-namespace").Create(context.TODO(), newPod("fake-pod2", "fake-node2"), meta.CreateOptions{})
+	}()
+	gates.EvictedPods = 10 * time.Second
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.NoError(s.T(), err)
+}
+
+// When workloads had pods on node
+// It must record them and wait they are back to their ready replicas of before drain
+func (s *TestSuite) TestUnsetDowntimeWhenWorkloadsNotReady() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-deploy-1234-abcd",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"pod-template-hash": "1234",
+				},
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "ReplicaSet",
+						Name:       "fake-deploy-1234",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-sts-0",
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "StatefulSet",
+						Name:       "fake-sts",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-sts2-0",
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "StatefulSet",
+						Name:       "fake-sts2",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&apps.Deployment{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-deploy",
+				Namespace: "fake-namespace",
+			},
+			Spec:   apps.DeploymentSpec{Replicas: ptr.To[int32](1)},
+			Status: apps.DeploymentStatus{ReadyReplicas: 1},
+		},
+		// Degraded before drain
+		&apps.StatefulSet{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-sts",
+				Namespace: "fake-namespace",
+			},
+			Spec:   apps.StatefulSetSpec{Replicas: ptr.To[int32](3)},
+			Status: apps.StatefulSetStatus{ReadyReplicas: 2},
+		},
+		&apps.StatefulSet{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-sts2",
+				Namespace: "fake-namespace",
+			},
+			Spec:   apps.StatefulSetSpec{Replicas: ptr.To[int32](1)},
+			Status: apps.StatefulSetStatus{ReadyReplicas: 1},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	drainOpts := kubetool.DefaultDrainOptions()
+	drainOpts.DisableEviction = true
+	drainOpts.Timeout = 10 * time.Second
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Drain: &drainOpts})
+	assert.NoError(s.T(), err)
+
+	state, err := cmd.GetDowntimeState(context.TODO(), "fake-node")
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []kubetool.Workload{
+		{Namespace: "fake-namespace", Kind: "Deployment", Name: "fake-deploy", ReadyReplicas: 1},
+		{Namespace: "fake-namespace", Kind: "StatefulSet", Name: "fake-sts", ReadyReplicas: 2},
+		{Namespace: "fake-namespace", Kind: "StatefulSet", Name: "fake-sts2", ReadyReplicas: 1},
+	}, state.Workloads)
+
+	// Deployment not yet ready after drain
+	deployment, err := fakeClient.AppsV1().Deployments("fake-namespace").Get(context.TODO(), "fake-deploy", meta.GetOptions{})
+	assert.NoError(s.T(), err)
+	deployment.Status.ReadyReplicas = 0
+	_, err = fakeClient.AppsV1().Deployments("fake-namespace").UpdateStatus(context.TODO(), deployment, meta.UpdateOptions{})
+	assert.NoError(s.T(), err)
+	gates := kubetool.DefaultGateTimeouts()
+	gates.Workloads = 100 * time.Millisecond
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.Error(s.T(), err)
+	assert.True(s.T(), kubetool.IsErrGateFailed(err))
+	assert.Contains(s.T(), err.Error(), "Deployment fake-namespace/fake-deploy has 0/1 ready replicas")
+
+	// Deployment become ready, StatefulSet stay degraded like before drain and other StatefulSet is removed
+	err = fakeClient.AppsV1().StatefulSets("fake-namespace").Delete(context.TODO(), "fake-sts2", meta.DeleteOptions{})
+	assert.NoError(s.T(), err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		deployment, _ := fakeClient.AppsV1().Deployments("fake-namespace").Get(context.TODO(), "fake-deploy", meta.GetOptions{})
+		deployment.Status.ReadyReplicas = 1
+		_, _ = fakeClient.AppsV1().Deployments("fake-namespace").UpdateStatus(context.TODO(), deployment, meta.UpdateOptions{})
+	}()
+	gates.Workloads = 10 * time.Second
+	err = unsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{Gates: &gates})
+	assert.NoError(s.T(), err)
+}
+
+// When pre hook veto the downtime
+// It must uncordon node without run post-jobs, and return the veto exit code
+func (s *TestSuite) TestSetDowntimeWhenHookVetoed() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "es-1",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"patchmanagement": "true",
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job":  "fake pre-job",
+				"post-job": "fake post-job",
+			},
+		},
+	)
+
+	// The pre hook exit with veto code, so the Job failed by pod failure policy
+	created := make([]*batch.Job, 0)
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		job.Namespace = action.GetNamespace()
+		created = append(created, job)
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobFailed,
+				Status: v1.ConditionTrue,
+				Reason: "PodFailurePolicy",
+			},
+		}
+		pod := newJobPod(job)
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{
+				Name: "pre-job",
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: kubetool.HookVetoExitCode, Message: "cluster is yellow\n"},
+				},
+			},
+		}
+		if err := fakeClient.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	opts := downtimeOptions{Report: newDowntimeReport("fake-node", "set-downtime")}
+	err := setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.ErrorContains(s.T(), err, "vetoed the downtime: cluster is yellow")
+	assert.True(s.T(), kubetool.IsErrHookVetoed(err))
+	assert.True(s.T(), kubetool.IsRescueUncordon(err))
+	assert.Equal(s.T(), kubetool.ExitCodeVetoed, rescueDowntime(context.TODO(), cmd, "fake-node", opts, err))
+
+	// Only the pre hook run, and it's not retried
+	if assert.Len(s.T(), created, 1) {
+		assert.Equal(s.T(), kubetool.HookPhasePre, created[0].Labels[kubetool.JobPhaseLabel])
+		if assert.NotNil(s.T(), created[0].Spec.PodFailurePolicy) {
+			assert.Equal(s.T(), batch.PodFailurePolicyActionFailJob, created[0].Spec.PodFailurePolicy.Rules[0].Action)
+			assert.Equal(s.T(), []int32{kubetool.HookVetoExitCode}, created[0].Spec.PodFailurePolicy.Rules[0].OnExitCodes.Values)
+		}
+	}
+	if assert.Len(s.T(), opts.Report.Jobs, 1) {
+		assert.Equal(s.T(), jobStatusVetoed, opts.Report.Jobs[0].Status)
+	}
+
+	node, err := fakeClient.CoreV1().Nodes().Get(context.TODO(), "fake-node", meta.GetOptions{})
 	assert.NoError(s.T(), err)
+	assert.False(s.T(), node.Spec.Unschedulable)
+	assert.Empty(s.T(), node.Annotations[kubetool.DowntimeStateAnnotation])
+}
+
+// When pre hook veto the downtime while other pre hook of the same wave failed
+// It must handle it as failure, with the rescue and the exit code of failure
+func (s *TestSuite) TestSetDowntimeWhenHookVetoedAndFailed() {
+	objects := []runtime.Object{
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+	}
+	for _, namespace := range []string{"es-namespace", "kafka-namespace"} {
+		objects = append(objects,
+			&v1.Pod{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      "fake-pod",
+					Namespace: namespace,
+					Labels: map[string]string{
+						"patchmanagement": "true",
+					},
+				},
+				Spec: v1.PodSpec{NodeName: "fake-node"},
+			},
+			&v1.ConfigMap{
+				ObjectMeta: meta.ObjectMeta{
+					Name:      "patchmanagement",
+					Namespace: namespace,
+				},
+				Data: map[string]string{
+					"pre-job": "fake pre-job",
+				},
+			},
+		)
+	}
+	fakeClient := fake.NewSimpleClientset(objects...)
+
+	// The pre hook of es-namespace veto the downtime, and the one of kafka-namespace failed
+	fakeClient.PrependReactor("create", "jobs", func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		job.Namespace = action.GetNamespace()
+		exitCode := int32(1)
+		if job.Namespace == "es-namespace" {
+			exitCode = kubetool.HookVetoExitCode
+		}
+		job.Status.Conditions = []batch.JobCondition{
+			{
+				Type:   batch.JobFailed,
+				Status: v1.ConditionTrue,
+				Reason: "BackoffLimitExceeded",
+			},
+		}
+		pod := newJobPod(job)
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{
+				Name: "pre-job",
+				State: v1.ContainerState{
+					Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode},
+				},
+			},
+		}
+		if err := fakeClient.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	opts := downtimeOptions{}
+	err := setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.ErrorContains(s.T(), err, "vetoed the downtime")
+	assert.False(s.T(), kubetool.IsErrHookVetoed(err))
+	assert.True(s.T(), kubetool.IsErrHookFailed(err))
+	assert.True(s.T(), kubetool.IsRescuePostJob(err))
+	assert.Equal(s.T(), kubetool.ExitCodeSkip, kubetool.ExitCode(err, kubetool.ExitCodeSkip))
+	assert.Equal(s.T(), kubetool.ExitCodeSkip, rescueDowntime(context.TODO(), cmd, "fake-node", opts, err))
 }
@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+
+	planStepDone = "done"
+	planStepTodo = "todo"
+)
+
+// downtimePlan is what set-downtime or unset-downtime will do on node
+type downtimePlan struct {
+	Node          string                 `json:"node"`
+	Command       string                 `json:"command"`
+	CurrentPhase  kubetool.DowntimePhase `json:"currentPhase"`
+	NodeReady     bool                   `json:"nodeReady"`
+	Unschedulable bool                   `json:"unschedulable"`
+	Steps         []planStep             `json:"steps"`
+	Namespaces    []namespacePlan        `json:"namespaces"`
+	Pods          []kubetool.DrainPod    `json:"pods,omitempty"`
+	BlockedPods   []kubetool.BlockedPod  `json:"blockedPods,omitempty"`
+}
+
+type planStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type namespacePlan struct {
+	Namespace  string     `json:"namespace"`
+	HasPreJob  bool       `json:"hasPreJob"`
+	HasPostJob bool       `json:"hasPostJob"`
+	Image      string     `json:"image,omitempty"`
+	Secrets    []string   `json:"secrets,omitempty"`
+	Order      int        `json:"order,omitempty"`
+	After      []string   `json:"after,omitempty"`
+	Hooks      []hookPlan `json:"hooks,omitempty"`
+	AlreadyRun bool       `json:"alreadyRun"`
+}
+
+type hookPlan struct {
+	Name          string `json:"name"`
+	Phase         string `json:"phase"`
+	FailurePolicy string `json:"failurePolicy"`
+	AlreadyRun    bool   `json:"alreadyRun"`
+
+	// TemplateError is the error when render the script of hook, that will stop the hooks
+	TemplateError string `json:"templateError,omitempty"`
+}
+
+// planSetDowntime compute what setDowntime will do, without write anything
+func planSetDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts downtimeOptions) (plan *downtimePlan, err error) {
+	plan, state, err := newPlan(ctx, cmd, nodeName, "set-downtime")
+	if err != nil {
+		return nil, err
+	}
+	if !state.IsDowntimePhase() {
+		state = kubetool.NewDowntimeState()
+	}
+
+	plan.addStep("check node", state.IsAfter(kubetool.DowntimePhaseChecked))
+	plan.addStep("cordon", state.IsAfter(kubetool.DowntimePhaseCordoned))
+	plan.addStep("run pre-jobs", state.IsAfter(kubetool.DowntimePhasePreJobsDone))
+	plan.addStep("drain", state.IsAfter(kubetool.DowntimePhaseDrained))
+
+	if err = plan.addNamespaces(ctx, cmd, kubetool.HookPhasePre, opts.hookRun(state.RunID, nodeName), state.HasPreHook); err != nil {
+		return nil, err
+	}
+
+	drainOpts := opts.drainOptions()
+	plan.Pods, err = cmd.PodsForDeletion(ctx, nodeName, drainOpts)
+	if err != nil {
+		log.Errorf("Error when compute pods to drain on node %s", nodeName)
+		return nil, err
+	}
+
+	plan.BlockedPods, err = cmd.CheckDrain(ctx, nodeName, drainOpts)
+	if err != nil {
+		log.Errorf("Error when check PodDisruptionBudget on node %s", nodeName)
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// planUnsetDowntime compute what unsetDowntime will do, without write anything
+func planUnsetDowntime(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, opts downtimeOptions) (plan *downtimePlan, err error) {
+	plan, state, err := newPlan(ctx, cmd, nodeName, "unset-downtime")
+	if err != nil {
+		return nil, err
+	}
+
+	plan.addStep("wait node ready and uncordon", state.IsAfter(kubetool.DowntimePhaseUncordoned))
+	plan.addStep("wait DaemonSet pods ready", state.IsAfter(kubetool.DowntimePhasePostJobsDone))
+	plan.addStep("wait evicted pods rescheduled", state.IsAfter(kubetool.DowntimePhasePostJobsDone))
+	plan.addStep("run post-jobs", state.IsAfter(kubetool.DowntimePhasePostJobsDone))
+	plan.addStep("wait pods", false)
+	plan.addStep("wait workloads ready", false)
+
+	isDone := func(namespace string, hook string) bool {
+		return state.IsAfter(kubetool.DowntimePhaseUncordoned) && state.HasPostHook(namespace, hook)
+	}
+	if err = plan.addNamespaces(ctx, cmd, kubetool.HookPhasePost, opts.hookRun(state.RunID, nodeName), isDone); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func newPlan(ctx context.Context, cmd *kubetool.Kubetool, nodeName string, command string) (plan *downtimePlan, state *kubetool.DowntimeState, err error) {
+	node, err := cmd.GetNode(ctx, nodeName)
+	if err != nil {
+		log.Errorf("Error when get node %s", nodeName)
+		return nil, nil, err
+	}
+	state, err = cmd.GetDowntimeState(ctx, nodeName)
+	if err != nil {
+		log.Errorf("Error when read the downtime state of node %s", nodeName)
+		return nil, nil, err
+	}
+	isOk, err := cmd.NodeOk(ctx, nodeName)
+	if err != nil {
+		log.Errorf("Error when check the node state for %s", nodeName)
+		return nil, nil, err
+	}
+
+	plan = &downtimePlan{
+		Node:          nodeName,
+		Command:       command,
+		CurrentPhase:  state.Phase,
+		NodeReady:     isOk,
+		Unschedulable: node.Spec.Unschedulable,
+		Steps:         make([]planStep, 0),
+		Namespaces:    make([]namespacePlan, 0),
+	}
+
+	return plan, state, nil
+}
+
+func (p *downtimePlan) addStep(name string, isDone bool) {
+	status := planStepTodo
+	if isDone {
+		status = planStepDone
+	}
+	p.Steps = append(p.Steps, planStep{Name: name, Status: status})
+}
+
+// addNamespaces permit to add the namespaces hosted on node with their hooks
+// The namespace is already run when all its hooks of the given phase already run. The scripts of hooks to run are rendered, to catch the template errors
+func (p *downtimePlan) addNamespaces(ctx context.Context, cmd *kubetool.Kubetool, phase string, run kubetool.HookRun, isDone func(namespace string, hook string) bool) (err error) {
+	namespaces, err := cmd.NamespacesPodsOnNode(ctx, p.Node)
+	if err != nil {
+		log.Errorf("Error when get all namespace for node %s", p.Node)
+		return err
+	}
+	for _, namespace := range namespaces {
+		jobSpec, err := cmd.GetJobSpec(ctx, namespace)
+		if err != nil {
+			log.Errorf("Error when try to get job spec on %s", namespace)
+			return err
+		}
+		namespacePlan := namespacePlan{
+			Namespace: namespace,
+		}
+		if jobSpec != nil {
+			namespacePlan.HasPreJob = len(jobSpec.HooksOf(kubetool.HookPhasePre)) > 0
+			namespacePlan.HasPostJob = len(jobSpec.HooksOf(kubetool.HookPhasePost)) > 0
+			namespacePlan.AlreadyRun = len(jobSpec.HooksOf(phase)) > 0
+			for _, hook := range jobSpec.Hooks {
+				hookPlan := hookPlan{
+					Name:          hook.Name,
+					Phase:         hook.Phase,
+					FailurePolicy: hook.FailurePolicy,
+				}
+				if hook.Phase == phase {
+					hookPlan.AlreadyRun = isDone(namespace, hook.Name)
+					namespacePlan.AlreadyRun = namespacePlan.AlreadyRun && hookPlan.AlreadyRun
+					if hook.HasTemplate() && !hookPlan.AlreadyRun {
+						if err = cmd.CheckHookTemplates(ctx, namespace, hook, run); err != nil {
+							hookPlan.TemplateError = err.Error()
+						}
+					}
+				}
+				namespacePlan.Hooks = append(namespacePlan.Hooks, hookPlan)
+			}
+			namespacePlan.Image = jobSpec.Image
+			namespacePlan.Secrets = jobSpec.SecretNames
+			namespacePlan.Order = jobSpec.Order
+			namespacePlan.After = jobSpec.After
+		}
+		p.Namespaces = append(p.Namespaces, namespacePlan)
+	}
+
+	return nil
+}
+
+// print permit to write the plan on given format
+func (p *downtimePlan) print(w io.Writer, format string) (err error) {
+	switch format {
+	case outputJSON:
+		b, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case outputText:
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Plan of %s on node %s\n", p.Command, p.Node)
+		fmt.Fprintf(&sb, "Current phase: %s\n", phaseOrNone(p.CurrentPhase))
+		fmt.Fprintf(&sb, "Node ready: %t, unschedulable: %t\n", p.NodeReady, p.Unschedulable)
+		fmt.Fprintln(&sb, "Steps:")
+		for _, step := range p.Steps {
+			fmt.Fprintf(&sb, "  - [%s] %s\n", step.Status, step.Name)
+		}
+		fmt.Fprintln(&sb, "Namespaces:")
+		for _, namespace := range p.Namespaces {
+			fmt.Fprintf(&sb, "  - %s: pre-job=%t post-job=%t already-run=%t", namespace.Namespace, namespace.HasPreJob, namespace.HasPostJob, namespace.AlreadyRun)
+			if namespace.Image != "" {
+				fmt.Fprintf(&sb, " image=%s", namespace.Image)
+			}
+			if len(namespace.Secrets) > 0 {
+				fmt.Fprintf(&sb, " secrets=%s", strings.Join(namespace.Secrets, ","))
+			}
+			if namespace.Order != 0 {
+				fmt.Fprintf(&sb, " order=%d", namespace.Order)
+			}
+			if len(namespace.After) > 0 {
+				fmt.Fprintf(&sb, " after=%s", strings.Join(namespace.After, ","))
+			}
+			fmt.Fprintln(&sb)
+			for _, hook := range namespace.Hooks {
+				fmt.Fprintf(&sb, "    - hook %s: phase=%s failure-policy=%s already-run=%t\n", hook.Name, hook.Phase, hook.FailurePolicy, hook.AlreadyRun)
+				if hook.TemplateError != "" {
+					fmt.Fprintf(&sb, "      template error: %s\n", hook.TemplateError)
+				}
+			}
+		}
+		if p.Pods != nil {
+			fmt.Fprintln(&sb, "Pods:")
+			for _, pod := range p.Pods {
+				fmt.Fprintf(&sb, "  - %s/%s: %s", pod.Namespace, pod.Name, pod.Action)
+				if pod.Reason != "" {
+					fmt.Fprintf(&sb, " (%s)", pod.Reason)
+				}
+				fmt.Fprintln(&sb)
+			}
+		}
+		if len(p.BlockedPods) > 0 {
+			fmt.Fprintln(&sb, "Pods blocked by PodDisruptionBudget:")
+			for _, pod := range p.BlockedPods {
+				fmt.Fprintf(&sb, "  - %s/%s: %s (%s)\n", pod.Namespace, pod.Name, pod.PodDisruptionBudget, pod.Reason)
+			}
+		}
+		_, err = io.WriteString(w, sb.String())
+		return err
+	default:
+		return errors.Errorf("Output format %s not supported", format)
+	}
+}
+
+func phaseOrNone(phase kubetool.DowntimePhase) string {
+	if phase == kubetool.DowntimePhaseNone {
+		return "none"
+	}
+	return string(phase)
+}
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	"github.com/stretchr/testify/assert"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// When plan set downtime
+// It must compute namespaces and pods without write anything
+func (s *TestSuite) TestPlanSetDowntime() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&apps.DaemonSet{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-ds",
+				Namespace: "fake-namespace",
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-rs-pod",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"patchmanagement": "true",
+				},
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "ReplicaSet",
+						Name:       "fake-rs",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-ds-pod",
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "DaemonSet",
+						Name:       "fake-ds",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"pre-job": "fake pre-job",
+				"secrets": "fake-secret",
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	plan, err := planSetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+	assert.True(s.T(), plan.NodeReady)
+	assert.Equal(s.T(), []namespacePlan{
+		{
+			Namespace: "fake-namespace",
+			HasPreJob: true,
+			Image:     "redhat/ubi8-minimal:latest",
+			Secrets:   []string{"fake-secret"},
+			Hooks: []hookPlan{
+				{Name: "pre-job", Phase: kubetool.HookPhasePre, FailurePolicy: kubetool.HookFailurePolicyAbort},
+			},
+		},
+	}, plan.Namespaces)
+	assert.ElementsMatch(s.T(), []kubetool.DrainPod{
+		{Namespace: "fake-namespace", Name: "fake-rs-pod", Action: kubetool.DrainActionEvict, Labels: map[string]string{"patchmanagement": "true"}, OwnerKind: "ReplicaSet", OwnerName: "fake-rs"},
+		{Namespace: "fake-namespace", Name: "fake-ds-pod", Action: kubetool.DrainActionIgnore, Reason: "DaemonSet-managed Pod", OwnerKind: "DaemonSet", OwnerName: "fake-ds"},
+		{Namespace: "fake-namespace", Name: "fake-pod", Action: kubetool.DrainActionForceDelete, Reason: "Pod declare no controller"},
+	}, plan.Pods)
+
+	// No write
+	for _, action := range fakeClient.Actions() {
+		assert.Contains(s.T(), []string{"get", "list"}, action.GetVerb())
+	}
+
+	// Print as json
+	buf := new(bytes.Buffer)
+	err = plan.print(buf, outputJSON)
+	assert.NoError(s.T(), err)
+	result := &downtimePlan{}
+	err = json.Unmarshal(buf.Bytes(), result)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), plan, result)
+
+	// Print as text
+	buf.Reset()
+	err = plan.print(buf, outputText)
+	assert.NoError(s.T(), err)
+	assert.Contains(s.T(), buf.String(), "fake-namespace/fake-pod: force-delete")
+}
+
+// When plan unset downtime after post jobs run on some namespaces
+// It must return the steps already done
+func (s *TestSuite) TestPlanUnsetDowntime() {
+
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+				Annotations: map[string]string{
+					kubetool.DowntimeStateAnnotation: `{"version":1,"runId":"fake-run","phase":"uncordoned","postJobs":["fake-namespace/post-job"]}`,
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"patchmanagement": "true",
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"post-job": "fake post-job",
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	plan, err := planUnsetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), kubetool.DowntimePhaseUncordoned, plan.CurrentPhase)
+	assert.Equal(s.T(), []planStep{
+		{Name: "wait node ready and uncordon", Status: planStepDone},
+		{Name: "wait DaemonSet pods ready", Status: planStepTodo},
+		{Name: "wait evicted pods rescheduled", Status: planStepTodo},
+		{Name: "run post-jobs", Status: planStepTodo},
+		{Name: "wait pods", Status: planStepTodo},
+		{Name: "wait workloads ready", Status: planStepTodo},
+	}, plan.Steps)
+	assert.Equal(s.T(), []namespacePlan{
+		{
+			Namespace:  "fake-namespace",
+			HasPostJob: true,
+			Image:      kubetool.DefaultHookImage,
+			Secrets:    []string{},
+			Hooks: []hookPlan{
+				{Name: "post-job", Phase: kubetool.HookPhasePost, FailurePolicy: kubetool.HookFailurePolicyAbort, AlreadyRun: true},
+			},
+			AlreadyRun: true,
+		},
+	}, plan.Namespaces)
+}
+
+// When plan set downtime with hook template that can't be rendered
+// It must report the template error on hook
+func (s *TestSuite) TestPlanSetDowntimeWithTemplate() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+				Labels: map[string]string{
+					"patchmanagement": "true",
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "patchmanagement",
+				Namespace: "fake-namespace",
+			},
+			Data: map[string]string{
+				"template": "true",
+				"pre-job":  "echo {{ .Unknown }}",
+			},
+		},
+	)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	plan, err := planSetDowntime(context.TODO(), cmd, "fake-node", downtimeOptions{})
+	assert.NoError(s.T(), err)
+	if assert.Len(s.T(), plan.Namespaces, 1) && assert.Len(s.T(), plan.Namespaces[0].Hooks, 1) {
+		assert.Contains(s.T(), plan.Namespaces[0].Hooks[0].TemplateError, "can't evaluate field Unknown")
+	}
+
+	var b bytes.Buffer
+	assert.NoError(s.T(), plan.print(&b, outputText))
+	assert.Contains(s.T(), b.String(), "template error: ")
+}
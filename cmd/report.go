@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	reportStatusRunning = "running"
+	reportStatusDone    = "done"
+	reportStatusFailed  = "failed"
+
+	jobStatusSuccess    = "success"
+	jobStatusFailed     = "failed"
+	jobStatusAlreadyRun = "already-run"
+
+	// jobStatusFailedIgnored is the status of failed hook with continue failure policy
+	jobStatusFailedIgnored = "failed-ignored"
+
+	// jobStatusVetoed is the status of pre hook that refused the downtime
+	jobStatusVetoed = "vetoed"
+)
+
+// downtimeReport is the machine readable report of set-downtime and unset-downtime
+// All methods can be called on nil report, so nothing is recorded when report is disabled
+type downtimeReport struct {
+	Node           string                 `json:"node"`
+	Command        string                 `json:"command"`
+	RunID          string                 `json:"runId,omitempty"`
+	StartTime      time.Time              `json:"startTime"`
+	EndTime        time.Time              `json:"endTime"`
+	ResumeFrom     kubetool.DowntimePhase `json:"resumeFrom,omitempty"`
+	Phases         []*phaseReport         `json:"phases"`
+	Jobs           []jobReport            `json:"jobs"`
+	EvictedPods    []kubetool.DrainPod    `json:"evictedPods"`
+	Rescues        []rescueReport         `json:"rescues"`
+	ExitCode       int                    `json:"exitCode"`
+	Classification string                 `json:"classification"`
+	Error          string                 `json:"error,omitempty"`
+}
+
+type phaseReport struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime,omitempty"`
+}
+
+type jobReport struct {
+	Namespace string `json:"namespace"`
+	Job       string `json:"job"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type rescueReport struct {
+	Action string `json:"action"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newDowntimeReport(nodeName string, command string) *downtimeReport {
+	return &downtimeReport{
+		Node:        nodeName,
+		Command:     command,
+		StartTime:   time.Now().UTC(),
+		Phases:      make([]*phaseReport, 0),
+		Jobs:        make([]jobReport, 0),
+		EvictedPods: make([]kubetool.DrainPod, 0),
+		Rescues:     make([]rescueReport, 0),
+	}
+}
+
+// getDowntimeReport return the report if it's asked by --report-file or --output json
+func getDowntimeReport(c *cli.Context, command string) *downtimeReport {
+	if c.String("report-file") == "" && c.String("output") != outputJSON {
+		return nil
+	}
+
+	return newDowntimeReport(c.String("node-name"), command)
+}
+
+// run record the ID of maintenance run, used as label on hook Jobs
+func (r *downtimeReport) run(runID string) {
+	if r == nil {
+		return
+	}
+	r.RunID = runID
+}
+
+// resume record the phase from which the command resume
+func (r *downtimeReport) resume(state *kubetool.DowntimeState) {
+	if r == nil {
+		return
+	}
+	r.ResumeFrom = state.Phase
+}
+
+// phase record the start of new phase. The previous phase is considered as done
+func (r *downtimeReport) phase(name string) {
+	if r == nil {
+		return
+	}
+	r.endPhase(reportStatusDone)
+	r.Phases = append(r.Phases, &phaseReport{
+		Name:      name,
+		Status:    reportStatusRunning,
+		StartTime: time.Now().UTC(),
+	})
+}
+
+func (r *downtimeReport) endPhase(status string) {
+	if len(r.Phases) == 0 {
+		return
+	}
+	current := r.Phases[len(r.Phases)-1]
+	if current.Status == reportStatusRunning {
+		current.Status = status
+		current.EndTime = time.Now().UTC()
+	}
+}
+
+// job record the result of job run on namespace
+func (r *downtimeReport) job(namespace string, job string, status string, err error) {
+	if r == nil {
+		return
+	}
+	r.Jobs = append(r.Jobs, jobReport{
+		Namespace: namespace,
+		Job:       job,
+		Status:    status,
+		Error:     errorString(err),
+	})
+}
+
+// evictedPods record the pods that drain delete or evict
+func (r *downtimeReport) evictedPods(pods []kubetool.DrainPod) {
+	if r == nil {
+		return
+	}
+	r.EvictedPods = make([]kubetool.DrainPod, 0, len(pods))
+	for _, pod := range pods {
+		switch pod.Action {
+		case kubetool.DrainActionEvict, kubetool.DrainActionDelete, kubetool.DrainActionForceDelete:
+			r.EvictedPods = append(r.EvictedPods, pod)
+		}
+	}
+}
+
+// rescue record the rescue action taken after failure
+func (r *downtimeReport) rescue(action string, err error) {
+	if r == nil {
+		return
+	}
+	status := reportStatusDone
+	if err != nil {
+		status = reportStatusFailed
+	}
+	r.Rescues = append(r.Rescues, rescueReport{
+		Action: action,
+		Status: status,
+		Error:  errorString(err),
+	})
+}
+
+// finish record the final result
+func (r *downtimeReport) finish(err error, exitCode int) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		r.endPhase(reportStatusFailed)
+	} else {
+		r.endPhase(reportStatusDone)
+	}
+	r.EndTime = time.Now().UTC()
+	r.ExitCode = exitCode
+	r.Classification = kubetool.ExitCodeName(exitCode)
+	r.Error = errorString(err)
+}
+
+func (r *downtimeReport) write(w io.Writer) (err error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// redirectOutputs permit to keep stdout for the JSON document with --output json
+// The logs and the drain progress are written on stderr instead
+func redirectOutputs(cmd *kubetool.Kubetool, output string) {
+	if output != outputJSON {
+		return
+	}
+	log.SetOutput(os.Stderr)
+	cmd.SetOutput(os.Stderr)
+}
+
+// writeReport permit to write the report on file and / or stdout
+func writeReport(c *cli.Context, r *downtimeReport) {
+	if r == nil {
+		return
+	}
+	if reportFile := c.String("report-file"); reportFile != "" {
+		f, err := os.Create(reportFile)
+		if err != nil {
+			log.Errorf("Error when create report file %s: %s", reportFile, err.Error())
+		} else {
+			if err = r.write(f); err != nil {
+				log.Errorf("Error when write report file %s: %s", reportFile, err.Error())
+			}
+			f.Close()
+		}
+	}
+	if c.String("output") == outputJSON {
+		if err := r.write(os.Stdout); err != nil {
+			log.Errorf("Error when write report: %s", err.Error())
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/disaster37/kubetool/v1.28/kubetool"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// When drain is blocked by PodDisruptionBudget
+// The report must record phases, rescue and the drain blocked exit code
+func (s *TestSuite) TestDowntimeReportWhenDrainBlocked() {
+	fakeClient := fake.NewSimpleClientset(newDrainCheckObjects(0)...)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+
+	opts := downtimeOptions{
+		Report: newDowntimeReport("fake-node", "set-downtime"),
+	}
+
+	err := setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.Error(s.T(), err)
+	exitCode := rescueDowntime(context.TODO(), cmd, "fake-node", opts, err)
+	assert.Equal(s.T(), kubetool.ExitCodeDrainBlocked, exitCode)
+	opts.Report.finish(err, exitCode)
+
+	buf := new(bytes.Buffer)
+	err = opts.Report.write(buf)
+	assert.NoError(s.T(), err)
+
+	report := &downtimeReport{}
+	err = json.Unmarshal(buf.Bytes(), report)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "fake-node", report.Node)
+	assert.Equal(s.T(), "set-downtime", report.Command)
+	assert.NotEmpty(s.T(), report.RunID)
+	assert.Equal(s.T(), kubetool.ExitCodeDrainBlocked, report.ExitCode)
+	assert.Equal(s.T(), "drain-blocked", report.Classification)
+	assert.NotEmpty(s.T(), report.Error)
+	if assert.Len(s.T(), report.Phases, 4) {
+		assert.Equal(s.T(), "lock", report.Phases[0].Name)
+		assert.Equal(s.T(), reportStatusDone, report.Phases[0].Status)
+		assert.Equal(s.T(), "check-drain", report.Phases[3].Name)
+		assert.Equal(s.T(), reportStatusFailed, report.Phases[3].Status)
+	}
+	if assert.Len(s.T(), report.Rescues, 3) {
+		assert.Equal(s.T(), "uncordon", report.Rescues[0].Action)
+		assert.Equal(s.T(), reportStatusDone, report.Rescues[0].Status)
+	}
+}
+
+// When report is disabled
+// It must not panic
+func (s *TestSuite) TestDowntimeReportWhenDisabled() {
+	var report *downtimeReport
+
+	report.phase("lock")
+	report.job("fake-namespace", "pre-job", jobStatusSuccess, nil)
+	report.rescue("uncordon", nil)
+	report.finish(nil, kubetool.ExitCodeOK)
+}
+
+// It must return the exit code of first typed error
+func (s *TestSuite) TestExitCode() {
+	assert.Equal(s.T(), kubetool.ExitCodeOK, kubetool.ExitCode(nil, kubetool.ExitCodeSkip))
+	assert.Equal(s.T(), kubetool.ExitCodeSkip, kubetool.ExitCode(errors.New("fake error"), kubetool.ExitCodeSkip))
+	assert.Equal(s.T(), kubetool.ExitCodeLocked, kubetool.ExitCode(kubetool.NewErrLocked("kube-system", []string{"other-node"}), kubetool.ExitCodeSkip))
+	assert.Equal(s.T(), kubetool.ExitCodeGateFailed, kubetool.ExitCode(kubetool.NewRescuePostJobError(kubetool.NewErrGateFailed(kubetool.GateNodeReady, "fake-node", errors.New("fake error"))), kubetool.ExitCodeStop))
+	assert.Equal(s.T(), "unknown", kubetool.ExitCodeName(99))
+}
+
+// When the report is printed with --output json
+// The stdout must only contain the report, the logs and the drain progress are written on stderr
+func (s *TestSuite) TestWriteReportWhenOutputJSON() {
+	fakeClient := fake.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta.ObjectMeta{
+				Name: "fake-node",
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{
+					{
+						Type:   v1.NodeReady,
+						Status: v1.ConditionTrue,
+					},
+				},
+			},
+		},
+		&v1.Pod{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      "fake-pod",
+				Namespace: "fake-namespace",
+				OwnerReferences: []meta.OwnerReference{
+					{
+						Kind:       "ReplicaSet",
+						Name:       "fake-rs",
+						Controller: ptr.To[bool](true),
+					},
+				},
+			},
+			Spec: v1.PodSpec{NodeName: "fake-node"},
+		},
+	)
+
+	// Capture stdout, where the logs are written like on main
+	stdout := os.Stdout
+	logOutput := logrus.StandardLogger().Out
+	reader, writer, err := os.Pipe()
+	assert.NoError(s.T(), err)
+	os.Stdout = writer
+	logrus.SetOutput(os.Stdout)
+	defer func() {
+		os.Stdout = stdout
+		logrus.SetOutput(logOutput)
+	}()
+	captured := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(reader)
+		captured <- b
+	}()
+
+	set := flag.NewFlagSet("set-downtime", flag.ContinueOnError)
+	set.String("output", outputJSON, "")
+	set.String("report-file", "", "")
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	cmd := kubetool.NewConnexionFromClient(fakeClient)
+	redirectOutputs(cmd, c.String("output"))
+
+	drainOpts := kubetool.DefaultDrainOptions()
+	drainOpts.DisableEviction = true
+	drainOpts.Timeout = 10 * time.Second
+	opts := downtimeOptions{
+		Drain:  &drainOpts,
+		Report: getDowntimeReport(c, "set-downtime"),
+	}
+	err = setDowntime(context.TODO(), cmd, "fake-node", opts)
+	assert.NoError(s.T(), err)
+	opts.Report.finish(err, kubetool.ExitCodeOK)
+	writeReport(c, opts.Report)
+
+	writer.Close()
+	report := &downtimeReport{}
+	err = json.Unmarshal(<-captured, report)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), "set-downtime", report.Command)
+	assert.Equal(s.T(), kubetool.ExitCodeOK, report.ExitCode)
+	assert.Len(s.T(), report.EvictedPods, 1)
+}
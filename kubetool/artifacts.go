@@ -0,0 +1,228 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// artifactsTimeout is how many time to collect the artifacts. It not depend of hook timeout, so the artifacts of hook in timeout are kept
+	artifactsTimeout = 30 * time.Second
+
+	// artifactsConfigMapMaxLogSize is how many bytes of logs, the last ones, are stored on ConfigMap
+	artifactsConfigMapMaxLogSize = 256 * 1024
+
+	// artifactsConfigMapSuffix is the suffix of the ConfigMap name, added to Job name
+	artifactsConfigMapSuffix = "-artifacts"
+)
+
+// hookArtifacts permit to store the logs, the status and the events of hook Job
+type hookArtifacts struct {
+	namespace string
+	jobName   string
+	hook      Hook
+	run       HookRun
+
+	// path is the files path without extension. It's empty when artifacts are not stored on directory
+	path string
+	file *os.File
+
+	// tail is the last logs, stored on ConfigMap
+	tail      []byte
+	truncated bool
+
+	mu sync.Mutex
+}
+
+// newHookArtifacts return the artifacts of hook Job, or nil when artifacts are disabled. The nil artifacts store nothing
+// The files are named by run, node, namespace, phase and hook on artifacts directory
+func newHookArtifacts(namespace string, jobName string, hook Hook, run HookRun) (a *hookArtifacts, err error) {
+	if run.ArtifactsDir == "" && !run.ArtifactsConfigMap {
+		return nil, nil
+	}
+
+	a = &hookArtifacts{
+		namespace: namespace,
+		jobName:   jobName,
+		hook:      hook,
+		run:       run,
+	}
+
+	if run.ArtifactsDir != "" {
+		nodeName := run.NodeName
+		if nodeName == "" {
+			nodeName = "none"
+		}
+		dir := filepath.Join(run.ArtifactsDir, run.ID)
+		if err = os.MkdirAll(dir, 0o755); err != nil {
+			return nil, errors.Wrapf(err, "Error when create artifacts directory %s", dir)
+		}
+		a.path = filepath.Join(dir, fmt.Sprintf("%s_%s_%s_%s", nodeName, namespace, hook.Phase, hook.Name))
+		// The Job has the same name when the run is resumed, so the logs of previous attempt are kept
+		if a.file, err = os.OpenFile(a.path+".log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			return nil, errors.Wrapf(err, "Error when open artifacts file %s.log", a.path)
+		}
+	}
+
+	return a, nil
+}
+
+// Write permit to store the logs of hook
+func (a *hookArtifacts) Write(p []byte) (n int, err error) {
+	if a == nil {
+		return len(p), nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		if n, err = a.file.Write(p); err != nil {
+			return n, err
+		}
+	}
+	if a.run.ArtifactsConfigMap {
+		a.tail = append(a.tail, p...)
+		if len(a.tail) > artifactsConfigMapMaxLogSize {
+			a.tail = a.tail[len(a.tail)-artifactsConfigMapMaxLogSize:]
+			a.truncated = true
+		}
+	}
+
+	return len(p), nil
+}
+
+// saveArtifacts permit to store the status of Job, its pods and events, and close the logs file
+// Errors are logged as warning and not returned: missing artifacts must not change the hook exit code
+func (k *Kubetool) saveArtifacts(a *hookArtifacts) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), artifactsTimeout)
+	defer cancelFunc()
+
+	if a.file != nil {
+		if err := a.file.Close(); err != nil {
+			log.Warnf("Error when close artifacts file %s.log: %s", a.path, err.Error())
+		}
+	}
+
+	job, err := k.client.BatchV1().Jobs(a.namespace).Get(ctx, a.jobName, meta.GetOptions{})
+	if err != nil {
+		log.Warnf("Error when get job %s/%s to store artifacts: %s", a.namespace, a.jobName, err.Error())
+		return
+	}
+	job.TypeMeta = meta.TypeMeta{APIVersion: "batch/v1", Kind: "Job"}
+	job.ManagedFields = nil
+
+	pods, err := k.jobPods(ctx, a.namespace, a.jobName)
+	if err != nil {
+		log.Warnf("Error when list pods of job %s/%s to store artifacts: %s", a.namespace, a.jobName, err.Error())
+	}
+	podNames := make([]string, 0, len(pods))
+	for i := range pods {
+		pods[i].TypeMeta = meta.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		pods[i].ManagedFields = nil
+		podNames = append(podNames, pods[i].Name)
+	}
+
+	events := make([]core.Event, 0)
+	involvedObjects := []core.ObjectReference{{Kind: "Job", Name: a.jobName}}
+	for _, podName := range podNames {
+		involvedObjects = append(involvedObjects, core.ObjectReference{Kind: "Pod", Name: podName})
+	}
+	for _, object := range involvedObjects {
+		eventList, err := k.client.CoreV1().Events(a.namespace).List(ctx, meta.ListOptions{FieldSelector: "involvedObject.name=" + object.Name})
+		if err != nil {
+			log.Warnf("Error when list events of %s %s/%s to store artifacts: %s", object.Kind, a.namespace, object.Name, err.Error())
+			continue
+		}
+		for _, event := range eventList.Items {
+			if event.InvolvedObject.Kind == object.Kind && event.InvolvedObject.Name == object.Name {
+				event.TypeMeta = meta.TypeMeta{APIVersion: "v1", Kind: "Event"}
+				event.ManagedFields = nil
+				events = append(events, event)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+
+	files := map[string]any{
+		"job.yaml":    job,
+		"pods.yaml":   &core.PodList{TypeMeta: meta.TypeMeta{APIVersion: "v1", Kind: "List"}, Items: pods},
+		"events.yaml": &core.EventList{TypeMeta: meta.TypeMeta{APIVersion: "v1", Kind: "List"}, Items: events},
+	}
+	data := make(map[string]string, len(files))
+	for name, o := range files {
+		b, err := yaml.Marshal(o)
+		if err != nil {
+			log.Warnf("Error when encode %s of job %s/%s: %s", name, a.namespace, a.jobName, err.Error())
+			continue
+		}
+		data[name] = string(b)
+	}
+
+	if a.path != "" {
+		for name, content := range data {
+			fileName := fmt.Sprintf("%s-%s", a.path, name)
+			if err = os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+				log.Warnf("Error when write artifacts file %s: %s", fileName, err.Error())
+			}
+		}
+		log.Infof("Artifacts of hook %s for %s stored on %s.*", a.hook.Name, a.namespace, a.path)
+	}
+
+	if a.run.ArtifactsConfigMap {
+		if err = k.saveArtifactsConfigMap(ctx, a, job, data["job.yaml"]); err != nil {
+			log.Warnf("Error when store artifacts of job %s/%s on ConfigMap: %s", a.namespace, a.jobName, err.Error())
+		}
+	}
+}
+
+// saveArtifactsConfigMap permit to store the last logs and the Job status on ConfigMap owned by Job
+// So the ConfigMap is removed with the Job
+func (k *Kubetool) saveArtifactsConfigMap(ctx context.Context, a *hookArtifacts, job *batch.Job, jobYAML string) (err error) {
+	logs := string(a.tail)
+	if a.truncated {
+		logs = fmt.Sprintf("[truncated, only the last %d bytes are kept]\n%s", artifactsConfigMapMaxLogSize, logs)
+	}
+
+	configMap := &core.ConfigMap{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      a.jobName + artifactsConfigMapSuffix,
+			Namespace: a.namespace,
+			Labels:    a.run.labels(a.hook),
+			OwnerReferences: []meta.OwnerReference{
+				*meta.NewControllerRef(job, batch.SchemeGroupVersion.WithKind("Job")),
+			},
+		},
+		Data: map[string]string{
+			"logs":     logs,
+			"job.yaml": jobYAML,
+		},
+	}
+
+	_, err = k.client.CoreV1().ConfigMaps(a.namespace).Create(ctx, configMap, meta.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		_, err = k.client.CoreV1().ConfigMaps(a.namespace).Update(ctx, configMap, meta.UpdateOptions{})
+	}
+
+	return err
+}
@@ -2,10 +2,13 @@ package kubetool
 
 import (
 	"context"
+	"strconv"
 	"strings"
+	"time"
 
+	"emperror.dev/errors"
 	log "github.com/sirupsen/logrus"
-	"k8s.io/apimachinery/pkg/api/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -16,7 +19,7 @@ func (k *Kubetool) GetJobSpec(ctx context.Context, namespace string) (job *Job,
 	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(ctx, "patchmanagement", metav1.GetOptions{})
 
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if kerrors.IsNotFound(err) {
 			log.Debugf("No pre-job found on %s", namespace)
 			return nil, nil
 		}
@@ -31,7 +34,7 @@ func (k *Kubetool) GetJobSpec(ctx context.Context, namespace string) (job *Job,
 	}
 
 	if job.Image == "" {
-		job.Image = "redhat/ubi8-minimal:latest"
+		job.Image = DefaultHookImage
 	}
 
 	if _, ok := configMap.Data["secrets"]; !ok {
@@ -45,5 +48,58 @@ func (k *Kubetool) GetJobSpec(ctx context.Context, namespace string) (job *Job,
 		}
 	}
 
+	if order, ok := configMap.Data["order"]; ok && order != "" {
+		job.Order, err = strconv.Atoi(strings.TrimSpace(order))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid order on configmap patchmanagement of %s", namespace)
+		}
+	}
+
+	after := strings.Split(configMap.Data["after"], ";")
+	for _, name := range after {
+		if name = strings.TrimSpace(name); name != "" {
+			job.After = append(job.After, name)
+		}
+	}
+
+	timeouts := map[string]*time.Duration{
+		"timeout":          &job.Timeout,
+		"pre-job-timeout":  &job.PreJobTimeout,
+		"post-job-timeout": &job.PostJobTimeout,
+	}
+	for key, timeout := range timeouts {
+		if value, ok := configMap.Data[key]; ok && strings.TrimSpace(value) != "" {
+			*timeout, err = time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid %s on configmap patchmanagement of %s", key, namespace)
+			}
+			if *timeout <= 0 {
+				return nil, errors.Errorf("Invalid %s on configmap patchmanagement of %s: it must be positive", key, namespace)
+			}
+		}
+	}
+
+	if value, ok := configMap.Data["template"]; ok && strings.TrimSpace(value) != "" {
+		job.Template, err = strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid template on configmap patchmanagement of %s", namespace)
+		}
+	}
+
+	if job.SecretVolumes, err = parseHookVolumes(namespace, "secret-volumes", configMap.Data["secret-volumes"]); err != nil {
+		return nil, err
+	}
+	if job.ConfigMapVolumes, err = parseHookVolumes(namespace, "configmap-volumes", configMap.Data["configmap-volumes"]); err != nil {
+		return nil, err
+	}
+
+	if job.JobTemplate, err = parseJobTemplate(namespace, configMap.Data["job-template"]); err != nil {
+		return nil, err
+	}
+
+	if err = job.parseHooks(namespace, configMap.Data); err != nil {
+		return nil, err
+	}
+
 	return job, nil
 }
@@ -1,13 +1,104 @@
 package kubetool
 
-import "emperror.dev/errors"
+import (
+	"fmt"
+	"strings"
+
+	"emperror.dev/errors"
+)
 
 const (
 	errNotReady        = "nodeNotReady"
+	errDrainBlocked    = "drainBlocked"
+	errLocked          = "locked"
+	errRetryExhausted  = "retryExhausted"
+	errGateFailed      = "gateFailed"
+	errHookFailed      = "hookFailed"
+	errHookVetoed      = "hookVetoed"
 	rescueTypeUncodron = "uncordon"
 	rescueTypePostJob  = "postJob"
 )
 
+// Exit codes of patch commands
+const (
+	// ExitCodeOK is returned when all work fine
+	ExitCodeOK = 0
+
+	// ExitCodeSkip is returned when something wrong, but the node is schedulable. We can loop on next node
+	ExitCodeSkip = 1
+
+	// ExitCodeStop is returned when something wrong and node is not schedulable or broken. We need to stop here
+	ExitCodeStop = 2
+
+	// ExitCodeNodeNotReady is returned when node is not ready before put it on downtime. Nothing changed on node
+	ExitCodeNodeNotReady = 3
+
+	// ExitCodeDrainBlocked is returned when drain is blocked by PodDisruptionBudget. The node is uncordoned
+	ExitCodeDrainBlocked = 4
+
+	// ExitCodeLocked is returned when the cluster wide lock is hold by other nodes. Nothing changed on node
+	ExitCodeLocked = 5
+
+	// ExitCodeGateFailed is returned when a readiness gate is not passed on unset downtime
+	ExitCodeGateFailed = 6
+
+	// ExitCodeRetryExhausted is returned when an action still failed after all attempts. The node is uncordoned
+	ExitCodeRetryExhausted = 7
+
+	// ExitCodeVetoed is returned when a pre hook refused the downtime. The node is uncordoned
+	ExitCodeVetoed = 8
+)
+
+var exitCodes = map[string]int{
+	errNotReady:       ExitCodeNodeNotReady,
+	errDrainBlocked:   ExitCodeDrainBlocked,
+	errLocked:         ExitCodeLocked,
+	errGateFailed:     ExitCodeGateFailed,
+	errRetryExhausted: ExitCodeRetryExhausted,
+	errHookVetoed:     ExitCodeVetoed,
+}
+
+var exitCodeNames = map[int]string{
+	ExitCodeOK:             "ok",
+	ExitCodeSkip:           "skip",
+	ExitCodeStop:           "stop",
+	ExitCodeNodeNotReady:   "node-not-ready",
+	ExitCodeDrainBlocked:   "drain-blocked",
+	ExitCodeLocked:         "locked",
+	ExitCodeGateFailed:     "gate-failed",
+	ExitCodeRetryExhausted: "retry-exhausted",
+	ExitCodeVetoed:         "vetoed",
+}
+
+// ExitCode return the exit code of the first typed error found on error chain, including the combined errors
+// It return defaultCode if error is not typed
+func ExitCode(err error, defaultCode int) int {
+	if err == nil {
+		return ExitCodeOK
+	}
+	vetoed := IsErrHookVetoed(err)
+	for _, typedError := range typedErrors(err) {
+		// The veto combined with failure is failure, see IsErrHookVetoed
+		if typedError.code == errHookVetoed && !vetoed {
+			continue
+		}
+		if exitCode, ok := exitCodes[typedError.code]; ok {
+			return exitCode
+		}
+	}
+
+	return defaultCode
+}
+
+// ExitCodeName return the classification of exit code
+func ExitCodeName(exitCode int) string {
+	if name, ok := exitCodeNames[exitCode]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
 // Errors represent typed error
 type Errors struct {
 	code       string
@@ -23,6 +114,99 @@ func NewErrNodeNotReady(nodeName string) error {
 	}
 }
 
+// NewErrDrainBlocked permit to return error of type drainBlocked
+func NewErrDrainBlocked(nodeName string, blockedPods []BlockedPod) error {
+	pods := make([]string, 0, len(blockedPods))
+	for _, pod := range blockedPods {
+		pods = append(pods, fmt.Sprintf("%s/%s (%s)", pod.Namespace, pod.Name, pod.PodDisruptionBudget))
+	}
+
+	return &Errors{
+		code: errDrainBlocked,
+		err:  errors.Errorf("Drain of node %s is blocked by PodDisruptionBudget: %s", nodeName, strings.Join(pods, ", ")),
+	}
+}
+
+// NewErrLocked permit to return error of type locked
+func NewErrLocked(namespace string, holders []string) error {
+	return &Errors{
+		code: errLocked,
+		err:  errors.Errorf("Can't acquire lock on namespace %s, it's already hold by: %s", namespace, strings.Join(holders, ", ")),
+	}
+}
+
+// NewErrRetryExhausted permit to return error of type retryExhausted
+func NewErrRetryExhausted(name string, attempts int, err error) error {
+	return &Errors{
+		code: errRetryExhausted,
+		err:  errors.Wrapf(err, "Give up %s after %d attempts", name, attempts),
+	}
+}
+
+// NewErrGateFailed permit to return error of type gateFailed
+func NewErrGateFailed(gate string, nodeName string, err error) error {
+	return &Errors{
+		code: errGateFailed,
+		err:  errors.Wrapf(err, "Readiness gate %s failed on node %s", gate, nodeName),
+	}
+}
+
+// NewErrHookFailed permit to return error of type hookFailed, when the Job of hook failed or can't run
+// The reason is the Job or pod reason, like BackoffLimitExceeded or ImagePullBackOff
+func NewErrHookFailed(namespace string, jobName string, reason string, detail string) error {
+	err := errors.Errorf("Job %s/%s failed: %s", namespace, jobName, reason)
+	if detail != "" {
+		err = errors.Errorf("Job %s/%s failed: %s: %s", namespace, jobName, reason, detail)
+	}
+
+	return &Errors{
+		code: errHookFailed,
+		err:  err,
+	}
+}
+
+// NewErrHookExecFailed permit to return error of type hookFailed, when the command of exec hook failed on pod
+// The reason is like ExitCode or Timeout, and the detail is the last line of output
+func NewErrHookExecFailed(namespace string, podName string, container string, reason string, detail string) error {
+	err := errors.Errorf("Exec on container %s of pod %s/%s failed: %s", container, namespace, podName, reason)
+	if detail != "" {
+		err = errors.Errorf("Exec on container %s of pod %s/%s failed: %s: %s", container, namespace, podName, reason, detail)
+	}
+
+	return &Errors{
+		code: errHookFailed,
+		err:  err,
+	}
+}
+
+// NewErrHookWebhookFailed permit to return error of type hookFailed, when the webhook of hook failed
+// The reason is like the unexpected HTTP status or Timeout, and the detail is the begin of response body
+func NewErrHookWebhookFailed(namespace string, hookName string, reason string, detail string) error {
+	err := errors.Errorf("Webhook of hook %s for %s failed: %s", hookName, namespace, reason)
+	if detail != "" {
+		err = errors.Errorf("Webhook of hook %s for %s failed: %s: %s", hookName, namespace, reason, detail)
+	}
+
+	return &Errors{
+		code: errHookFailed,
+		err:  err,
+	}
+}
+
+// NewErrHookVetoed permit to return error of type hookVetoed, when the pre hook refused the downtime
+// The object is the Job or the pod of hook, like "Job namespace/name". The reason is the termination message of hook container
+func NewErrHookVetoed(object string, reason string) error {
+	err := errors.Errorf("%s vetoed the downtime", object)
+	if reason != "" {
+		err = errors.Errorf("%s vetoed the downtime: %s", object, reason)
+	}
+
+	return &Errors{
+		code: errHookVetoed,
+		err:  err,
+	}
+}
+
 // NewRescueError permit to return error of type rescue that need uncordon step
 func NewRescueUncordonError(err error) error {
 	return &Errors{
@@ -41,9 +225,51 @@ func NewRescuePostJobError(err error) error {
 
 // IsErrNodeNotReady permit to check if error is type of nodeNotReady
 func IsErrNodeNotReady(err error) bool {
-	errors, ok := err.(*Errors)
-	if ok && errors.code == errNotReady {
-		return true
+	return hasCode(err, errNotReady)
+}
+
+// IsErrDrainBlocked permit to check if error is type of drainBlocked
+func IsErrDrainBlocked(err error) bool {
+	return hasCode(err, errDrainBlocked)
+}
+
+// IsErrLocked permit to check if error is type of locked
+func IsErrLocked(err error) bool {
+	return hasCode(err, errLocked)
+}
+
+// IsErrRetryExhausted permit to check if error is type of retryExhausted
+func IsErrRetryExhausted(err error) bool {
+	return hasCode(err, errRetryExhausted)
+}
+
+// IsErrGateFailed permit to check if error is type of gateFailed
+func IsErrGateFailed(err error) bool {
+	return hasCode(err, errGateFailed)
+}
+
+// IsErrHookFailed permit to check if error is type of hookFailed
+func IsErrHookFailed(err error) bool {
+	return hasCode(err, errHookFailed)
+}
+
+// IsErrHookVetoed permit to check if error is type of hookVetoed
+// When errors are combined, all of them must be type of hookVetoed. So the veto on one namespace and the failure on other is failure
+func IsErrHookVetoed(err error) bool {
+	for err != nil {
+		if e, ok := err.(*Errors); ok && e.code == errHookVetoed {
+			return true
+		}
+		if combined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs := combined.Unwrap()
+			for _, e := range errs {
+				if !IsErrHookVetoed(e) {
+					return false
+				}
+			}
+			return len(errs) > 0
+		}
+		err = errors.Unwrap(err)
 	}
 
 	return false
@@ -69,6 +295,40 @@ func IsRescuePostJob(err error) bool {
 	return false
 }
 
+// hasCode permit to check if error or one of wrapped errors has the given code
+func hasCode(err error, code string) bool {
+	for _, e := range typedErrors(err) {
+		if e.code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// typedErrors return the typed errors found on error chain, on order. The combined errors are walked one after another
+func typedErrors(err error) (typed []*Errors) {
+	for err != nil {
+		if e, ok := err.(*Errors); ok {
+			typed = append(typed, e)
+		}
+		if combined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range combined.Unwrap() {
+				typed = append(typed, typedErrors(e)...)
+			}
+			return typed
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return typed
+}
+
+// Unwrap permit to get the original error
+func (e *Errors) Unwrap() error {
+	return e.err
+}
+
 // Err implement error interface
 func (e *Errors) Error() string {
 	return e.err.Error()
@@ -0,0 +1,262 @@
+package kubetool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// HookExec is the pods and the command of exec hook, like the exec hooks of Velero
+type HookExec struct {
+	// Selector is the label selector of pods where run the command. Only the running pods hosted on node are used
+	Selector string `json:"selector"`
+
+	// Container is the container where run the command. Default to the first container of pod
+	Container string `json:"container,omitempty"`
+
+	// Command is the command to run. Default to the script of hook, run with /bin/sh -c
+	Command []string `json:"command,omitempty"`
+}
+
+// PodExecutor permit to run command on container of pod, like kubectl exec
+// It return error that implement k8s.io/client-go/util/exec.ExitError when the command exit with non zero code
+type PodExecutor interface {
+	Exec(ctx context.Context, namespace string, podName string, container string, command []string, stdout io.Writer, stderr io.Writer) error
+}
+
+// spdyExecutor permit to run command on pod through the pods/exec subresource
+type spdyExecutor struct {
+	client kubernetes.Interface
+
+	// config is nil when the connexion is not created from kube config
+	config *rest.Config
+}
+
+// Exec permit to run command on container of pod and stream its output
+func (e *spdyExecutor) Exec(ctx context.Context, namespace string, podName string, container string, command []string, stdout io.Writer, stderr io.Writer) error {
+	if e.config == nil {
+		return errors.New("Exec on pod need connexion from kube config")
+	}
+
+	req := e.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&core.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return errors.Wrapf(err, "Error when create executor for pod %s/%s", namespace, podName)
+	}
+
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// validateHookExec permit to check the pods and the command of exec hook
+func validateHookExec(h Hook) error {
+	if h.Exec == nil || strings.TrimSpace(h.Exec.Selector) == "" {
+		return errors.Errorf("Selector of exec hook %s must be provided", h.Name)
+	}
+	if _, err := labels.Parse(h.Exec.Selector); err != nil {
+		return errors.Wrapf(err, "Invalid selector of exec hook %s", h.Name)
+	}
+	if len(h.Exec.Command) == 0 && h.Script == "" {
+		return errors.Errorf("Command or script of exec hook %s must be provided", h.Name)
+	}
+
+	return nil
+}
+
+// RunHook permit to run the hook according to its type
+func (k *Kubetool) RunHook(ctx context.Context, namespace string, hook Hook, run HookRun) (err error) {
+	switch hook.Type {
+	case HookTypeExec:
+		return k.RunExec(ctx, namespace, hook, run)
+	case HookTypeWebhook:
+		return k.RunWebhook(ctx, namespace, hook, run)
+	default:
+		return k.RunJob(ctx, namespace, hook, run)
+	}
+}
+
+// RunExec permit to run the command of exec hook on the containers of pods hosted on node, one pod after another
+// The output is printed line by line, prefixed by [namespace/pod/container]. It stop on first pod where the command failed
+func (k *Kubetool) RunExec(ctx context.Context, namespace string, hook Hook, run HookRun) (err error) {
+	timeout := hook.timeout(run)
+	ctx, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	hookContext, err := k.hookContext(ctx, namespace, hook, run)
+	if err != nil {
+		return err
+	}
+	command := hook.Exec.Command
+	if len(command) == 0 {
+		script, err := hookContext.render(hook)
+		if err != nil {
+			return err
+		}
+		command = []string{"/bin/sh", "-c", script}
+	}
+
+	pods, err := k.execPods(ctx, namespace, hook, run)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		log.Infof("No running pod match %s on %s for hook %s, skip it", hook.Exec.Selector, namespace, hook.Name)
+		return nil
+	}
+
+	for _, pod := range pods {
+		container := hook.Exec.Container
+		if container == "" {
+			container = pod.Spec.Containers[0].Name
+		}
+		if !hasContainer(&pod, container) {
+			return errors.Errorf("Container %s used by hook %s not found on pod %s/%s", container, hook.Name, namespace, pod.Name)
+		}
+
+		prefix := fmt.Sprintf("[%s/%s/%s]", namespace, pod.Name, container)
+		log.Infof("%s Run hook %s", prefix, hook.Name)
+		stdout := &lineWriter{prefix: prefix}
+		stderr := &lineWriter{prefix: prefix}
+		err = k.podExecutor.Exec(ctx, namespace, pod.Name, container, command, stdout, stderr)
+		stdout.Flush()
+		stderr.Flush()
+		lastLine := stderr.Last()
+		if lastLine == "" {
+			lastLine = stdout.Last()
+		}
+
+		if err != nil {
+			var exitErr utilexec.ExitError
+			switch {
+			case errors.As(err, &exitErr) && exitErr.ExitStatus() == HookVetoExitCode && hook.Phase == HookPhasePre:
+				return NewErrHookVetoed(fmt.Sprintf("Pod %s/%s", namespace, pod.Name), lastLine)
+			case errors.As(err, &exitErr):
+				return NewErrHookExecFailed(namespace, pod.Name, container, fmt.Sprintf("exited with code %d", exitErr.ExitStatus()), lastLine)
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				return NewErrHookExecFailed(namespace, pod.Name, container, "Timeout", fmt.Sprintf("hook %s not finished after %s", hook.Name, timeout))
+			default:
+				return errors.Wrapf(err, "Error when exec hook %s on pod %s/%s", hook.Name, namespace, pod.Name)
+			}
+		}
+		log.Debugf("%s Hook %s run successfully", prefix, hook.Name)
+	}
+
+	return nil
+}
+
+// execPods return the running pods of namespace that match the selector of exec hook, hosted on node. All nodes when the hook is run without node
+func (k *Kubetool) execPods(ctx context.Context, namespace string, hook Hook, run HookRun) (pods []core.Pod, err error) {
+	listOptions := meta.ListOptions{LabelSelector: hook.Exec.Selector}
+	if run.NodeName != "" {
+		listOptions.FieldSelector = "spec.nodeName=" + run.NodeName
+	}
+	podList, err := k.client.CoreV1().Pods(namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when list pods of %s to run hook %s", namespace, hook.Name)
+	}
+
+	pods = make([]core.Pod, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		if run.NodeName != "" && pod.Spec.NodeName != run.NodeName {
+			continue
+		}
+		if pod.Status.Phase != core.PodRunning || pod.DeletionTimestamp != nil {
+			log.Debugf("Pod %s/%s is not running, skip it for hook %s", namespace, pod.Name, hook.Name)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+
+	return pods, nil
+}
+
+func hasContainer(pod *core.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineWriter permit to log each line written, prefixed. It keep the last line, used as reason when the command failed
+type lineWriter struct {
+	prefix string
+	buf    []byte
+	last   string
+	mu     sync.Mutex
+}
+
+// Write permit to log the complete lines, the partial line is kept until the next write
+func (w *lineWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.print(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush permit to log the partial line, when the command is finished
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.print(string(w.buf))
+	w.buf = nil
+}
+
+// Last return the last line not empty
+func (w *lineWriter) Last() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.last
+}
+
+func (w *lineWriter) print(line string) {
+	if line = strings.TrimRight(line, "\r"); line != "" {
+		log.Infof("%s %s", w.prefix, line)
+		w.last = line
+	}
+}
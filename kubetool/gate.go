@@ -0,0 +1,268 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	GateNodeReady     = "node-ready"
+	GateDaemonSetPods = "daemonset-pods"
+	GateEvictedPods   = "evicted-pods"
+	GateWorkloads     = "workloads"
+)
+
+// GateTimeouts is how long to wait each readiness gate. 0 disable the gate
+type GateTimeouts struct {
+	NodeReady     time.Duration
+	DaemonSetPods time.Duration
+	EvictedPods   time.Duration
+	Workloads     time.Duration
+}
+
+// DefaultGateTimeouts return the default timeout of readiness gates
+func DefaultGateTimeouts() GateTimeouts {
+	return GateTimeouts{
+		NodeReady:     10 * time.Minute,
+		DaemonSetPods: 5 * time.Minute,
+		EvictedPods:   10 * time.Minute,
+		Workloads:     10 * time.Minute,
+	}
+}
+
+// EvictedWorkload represent the controller of pods evicted by drain
+type EvictedWorkload struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+
+	// ReadyPods is the number of ready pods of the controller before drain
+	ReadyPods int `json:"readyPods"`
+}
+
+// EvictedWorkloads permit to compute the controllers of pods that drain will evict, with their number of ready pods
+// The pods are the result of PodsForDeletion
+func (k *Kubetool) EvictedWorkloads(ctx context.Context, pods []DrainPod) (workloads []EvictedWorkload, err error) {
+	workloads = make([]EvictedWorkload, 0)
+	for _, pod := range pods {
+		if (pod.Action != DrainActionEvict && pod.Action != DrainActionDelete) || pod.OwnerName == "" {
+			continue
+		}
+		if indexWorkload(workloads, pod.Namespace, pod.OwnerKind, pod.OwnerName) < 0 {
+			workloads = append(workloads, EvictedWorkload{
+				Namespace: pod.Namespace,
+				Kind:      pod.OwnerKind,
+				Name:      pod.OwnerName,
+			})
+		}
+	}
+
+	podLists := map[string]*v1.PodList{}
+	for i := range workloads {
+		podList, ok := podLists[workloads[i].Namespace]
+		if !ok {
+			podList, err = k.client.CoreV1().Pods(workloads[i].Namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			podLists[workloads[i].Namespace] = podList
+		}
+		workloads[i].ReadyPods = workloads[i].countReadyPods(podList.Items)
+	}
+
+	return workloads, nil
+}
+
+// WaitNodeReady permit to wait node is on ready state
+func (k *Kubetool) WaitNodeReady(ctx context.Context, nodeName string, timeout time.Duration) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	check := func(ctx context.Context) (resourceVersion string, reason string, err error) {
+		node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return "", "", err
+		}
+		if !isNodeReady(node) {
+			return node.ResourceVersion, "node is not ready", nil
+		}
+		return node.ResourceVersion, "", nil
+	}
+	watcher := func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+		return k.client.CoreV1().Nodes().Watch(ctx, metav1.ListOptions{
+			FieldSelector:   "metadata.name=" + nodeName,
+			ResourceVersion: resourceVersion,
+		})
+	}
+
+	return waitGate(ctx, GateNodeReady, nodeName, timeout, check, watcher)
+}
+
+// WaitDaemonSetPodsReady permit to wait all DaemonSet pods hosted on node are on ready state
+func (k *Kubetool) WaitDaemonSetPodsReady(ctx context.Context, nodeName string, timeout time.Duration) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	check := func(ctx context.Context) (resourceVersion string, reason string, err error) {
+		podList, err := k.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+			FieldSelector: "spec.nodeName=" + nodeName,
+		})
+		if err != nil {
+			return "", "", err
+		}
+		for _, pod := range podList.Items {
+			controllerRef := metav1.GetControllerOf(&pod)
+			if controllerRef == nil || controllerRef.Kind != "DaemonSet" {
+				continue
+			}
+			if !isPodReady(&pod) {
+				return podList.ResourceVersion, fmt.Sprintf("pod %s/%s is not ready", pod.Namespace, pod.Name), nil
+			}
+		}
+		return podList.ResourceVersion, "", nil
+	}
+	watcher := func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+		return k.client.CoreV1().Pods("").Watch(ctx, metav1.ListOptions{
+			FieldSelector:   "spec.nodeName=" + nodeName,
+			ResourceVersion: resourceVersion,
+		})
+	}
+
+	return waitGate(ctx, GateDaemonSetPods, nodeName, timeout, check, watcher)
+}
+
+// WaitEvictedWorkloadsReady permit to wait the pods evicted by drain are rescheduled and ready somewhere
+func (k *Kubetool) WaitEvictedWorkloadsReady(ctx context.Context, nodeName string, workloads []EvictedWorkload, timeout time.Duration) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	if timeout <= 0 || len(workloads) == 0 {
+		return waitGate(ctx, GateEvictedPods, nodeName, timeout, nil, nil)
+	}
+
+	// All namespaces share the same timeout
+	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	namespaces := make([]string, 0)
+	for _, workload := range workloads {
+		if !contains(namespaces, workload.Namespace) {
+			namespaces = append(namespaces, workload.Namespace)
+		}
+	}
+
+	for _, namespace := range namespaces {
+		check := func(ctx context.Context) (resourceVersion string, reason string, err error) {
+			podList, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return "", "", err
+			}
+			for _, workload := range workloads {
+				if workload.Namespace != namespace {
+					continue
+				}
+				if readyPods := workload.countReadyPods(podList.Items); readyPods < workload.ReadyPods {
+					return podList.ResourceVersion, fmt.Sprintf("%s %s/%s has %d/%d ready pods", workload.Kind, workload.Namespace, workload.Name, readyPods, workload.ReadyPods), nil
+				}
+			}
+			return podList.ResourceVersion, "", nil
+		}
+		watcher := func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+			return k.client.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
+				ResourceVersion: resourceVersion,
+			})
+		}
+
+		if err = waitGate(ctxWithTimeout, GateEvictedPods, nodeName, timeout, check, watcher); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitGate permit to list objects and watch them until the check pass or timeout
+// The check return empty reason when the gate is passed
+func waitGate(ctx context.Context, gate string, nodeName string, timeout time.Duration, check func(ctx context.Context) (resourceVersion string, reason string, err error), watcher func(ctx context.Context, resourceVersion string) (watch.Interface, error)) (err error) {
+	if timeout <= 0 || check == nil {
+		log.Debugf("Readiness gate %s skipped on node %s", gate, nodeName)
+		return nil
+	}
+
+	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	reason := ""
+	gateError := func(err error) error {
+		if errors.Is(ctxWithTimeout.Err(), context.DeadlineExceeded) {
+			return NewErrGateFailed(gate, nodeName, errors.Errorf("Timeout after %s, %s", timeout, reason))
+		}
+		return NewErrGateFailed(gate, nodeName, err)
+	}
+
+	for {
+		resourceVersion, currentReason, err := check(ctxWithTimeout)
+		if err != nil {
+			return gateError(err)
+		}
+		if currentReason == "" {
+			log.Infof("Readiness gate %s passed on node %s", gate, nodeName)
+			return nil
+		}
+		reason = currentReason
+		log.Infof("Readiness gate %s not yet passed on node %s: %s, we wait ...", gate, nodeName, reason)
+
+		w, err := watcher(ctxWithTimeout, resourceVersion)
+		if err != nil {
+			return gateError(err)
+		}
+
+		// Any change trigger a new check
+		select {
+		case <-ctxWithTimeout.Done():
+			w.Stop()
+			return gateError(ctxWithTimeout.Err())
+		case <-w.ResultChan():
+			w.Stop()
+		}
+	}
+}
+
+// countReadyPods return the number of ready pods controlled by workload
+func (w EvictedWorkload) countReadyPods(pods []v1.Pod) (nb int) {
+	for _, pod := range pods {
+		controllerRef := metav1.GetControllerOf(&pod)
+		if controllerRef == nil || controllerRef.Kind != w.Kind || controllerRef.Name != w.Name {
+			continue
+		}
+		if pod.DeletionTimestamp == nil && isPodReady(&pod) {
+			nb++
+		}
+	}
+
+	return nb
+}
+
+func indexWorkload(workloads []EvictedWorkload, namespace string, kind string, name string) int {
+	for i, workload := range workloads {
+		if workload.Namespace == namespace && workload.Kind == kind && workload.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
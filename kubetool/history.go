@@ -0,0 +1,75 @@
+package kubetool
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pruneJobsTimeout is how many time to remove the oldest Jobs
+const pruneJobsTimeout = 30 * time.Second
+
+// pruneJobs permit to remove the oldest finished hook Jobs of namespace, so only history Jobs are kept including the current one
+// The running Jobs, from concurrent runs, are never removed. When it failed, the Jobs are kept until the next hook of namespace
+func (k *Kubetool) pruneJobs(namespace string, currentJobName string, history int) {
+	if history < 1 {
+		return
+	}
+
+	// The context of hook is already done when the hook is in timeout, that is when the Jobs pile up
+	ctx, cancelFunc := context.WithTimeout(context.Background(), pruneJobsTimeout)
+	defer cancelFunc()
+
+	jobList, err := k.client.BatchV1().Jobs(namespace).List(ctx, meta.ListOptions{LabelSelector: JobHookLabel})
+	if err != nil {
+		log.Warnf("Error when list hook jobs on %s to remove the oldest: %s", namespace, err.Error())
+		return
+	}
+
+	jobs := make([]batch.Job, 0, len(jobList.Items))
+	for _, job := range jobList.Items {
+		if job.Name != currentJobName {
+			jobs = append(jobs, job)
+		}
+	}
+
+	// Newest first
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].CreationTimestamp.Equal(&jobs[j].CreationTimestamp) {
+			return jobs[i].Name > jobs[j].Name
+		}
+		return jobs[j].CreationTimestamp.Before(&jobs[i].CreationTimestamp)
+	})
+
+	deleteOption := meta.DeletePropagationBackground
+	for i, job := range jobs {
+		if i < history-1 || !isJobFinished(&job) {
+			continue
+		}
+		log.Debugf("Remove old hook job %s/%s", namespace, job.Name)
+		err = k.client.BatchV1().Jobs(namespace).Delete(ctx, job.Name, meta.DeleteOptions{PropagationPolicy: &deleteOption})
+		if err != nil && !kerrors.IsNotFound(err) {
+			log.Warnf("Error when remove old hook job %s/%s: %s", namespace, job.Name, err.Error())
+		}
+	}
+}
+
+// isJobFinished return true if the Job is completed, failed or suspended, like the Job that vetoed the downtime while running
+func isJobFinished(job *batch.Job) bool {
+	if job.Spec.Suspend != nil && *job.Spec.Suspend {
+		return true
+	}
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batch.JobComplete || condition.Type == batch.JobFailed) && condition.Status == core.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
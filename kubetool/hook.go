@@ -0,0 +1,246 @@
+package kubetool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	batch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	HookPhasePre  = "pre"
+	HookPhasePost = "post"
+
+	HookFailurePolicyAbort    = "abort"
+	HookFailurePolicyContinue = "continue"
+
+	// HookTypeJob run the script on Job, HookTypeExec run the command on the containers of pods hosted on node
+	// and HookTypeWebhook call HTTP endpoint
+	HookTypeJob     = "job"
+	HookTypeExec    = "exec"
+	HookTypeWebhook = "webhook"
+
+	// DefaultHookImage is the image used by hook when no image is provided
+	DefaultHookImage = "redhat/ubi8-minimal:latest"
+
+	// DefaultHookTimeout is how many time to wait hook when no timeout is provided
+	DefaultHookTimeout = 30 * time.Minute
+
+	// hookDeadlineGrace is how many time to wait the Job after its activeDeadlineSeconds, so its DeadlineExceeded failure is reported
+	hookDeadlineGrace = time.Minute
+
+	// hookJobPrefix is the prefix of Job name created by hook
+	hookJobPrefix = "patchmanagement-"
+
+	// HookVetoExitCode is the exit code of pre hook that refuse the downtime of node. The termination message is the reason
+	HookVetoExitCode = 42
+)
+
+// Hook is the action run on namespace before (pre) or after (post) the downtime of node
+type Hook struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type,omitempty"`
+	Phase         string          `json:"phase"`
+	Script        string          `json:"script,omitempty"`
+	Image         string          `json:"image,omitempty"`
+	Secrets       []string        `json:"secrets,omitempty"`
+	Timeout       metav1.Duration `json:"timeout,omitempty"`
+	FailurePolicy string          `json:"failurePolicy,omitempty"`
+
+	// Template is true when the script is Go template, rendered with the context of run
+	Template bool `json:"template,omitempty"`
+
+	// SecretVolumes and ConfigMapVolumes are mounted as files on hook container
+	SecretVolumes    []HookVolume `json:"secretVolumes,omitempty"`
+	ConfigMapVolumes []HookVolume `json:"configMapVolumes,omitempty"`
+
+	// Exec is the pods and the command of exec hook
+	Exec *HookExec `json:"exec,omitempty"`
+
+	// Webhook is the HTTP request of webhook hook
+	Webhook *HookWebhook `json:"webhook,omitempty"`
+
+	// JobTemplate is the strategic merge patch applied on Job, from the key job-template of configmap
+	JobTemplate []byte `json:"-"`
+}
+
+// IsContinueOnFailure return true if the downtime continue when the hook failed
+func (h Hook) IsContinueOnFailure() bool {
+	return h.FailurePolicy == HookFailurePolicyContinue
+}
+
+// HooksOf return the hooks of the given phase, on the order they are declared
+func (j *Job) HooksOf(phase string) (hooks []Hook) {
+	hooks = make([]Hook, 0, len(j.Hooks))
+	for _, hook := range j.Hooks {
+		if hook.Phase == phase {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks
+}
+
+// parseHooks permit to read the hooks from the YAML list stored on key hooks of configmap
+// The legacy keys pre-job and post-job become the hooks named pre-job and post-job
+func (j *Job) parseHooks(namespace string, data map[string]string) (err error) {
+	j.Hooks = make([]Hook, 0)
+
+	if j.PreJob != "" {
+		j.Hooks = append(j.Hooks, Hook{Name: "pre-job", Phase: HookPhasePre, Script: j.PreJob})
+	}
+	if j.PostJob != "" {
+		j.Hooks = append(j.Hooks, Hook{Name: "post-job", Phase: HookPhasePost, Script: j.PostJob})
+	}
+
+	if value := data["hooks"]; strings.TrimSpace(value) != "" {
+		hooks := make([]Hook, 0)
+		if err = yaml.UnmarshalStrict([]byte(value), &hooks); err != nil {
+			return errors.Wrapf(err, "Invalid hooks on configmap patchmanagement of %s", namespace)
+		}
+		j.Hooks = append(j.Hooks, hooks...)
+	}
+
+	names := make([]string, 0, len(j.Hooks))
+	for i := range j.Hooks {
+		hook := &j.Hooks[i]
+		if hook.Image == "" {
+			hook.Image = j.Image
+		}
+		if hook.Secrets == nil {
+			hook.Secrets = j.SecretNames
+		}
+		if hook.SecretVolumes == nil {
+			hook.SecretVolumes = j.SecretVolumes
+		}
+		if hook.ConfigMapVolumes == nil {
+			hook.ConfigMapVolumes = j.ConfigMapVolumes
+		}
+		if hook.Type == "" {
+			hook.Type = HookTypeJob
+		}
+		if hook.FailurePolicy == "" {
+			hook.FailurePolicy = HookFailurePolicyAbort
+		}
+		if hook.Timeout.Duration == 0 {
+			hook.Timeout.Duration = j.phaseTimeout(hook.Phase)
+		}
+		hook.Template = hook.Template || j.Template
+		hook.JobTemplate = j.JobTemplate
+
+		if err = hook.validate(); err != nil {
+			return errors.Wrapf(err, "Invalid hook on configmap patchmanagement of %s", namespace)
+		}
+		if contains(names, hook.Name) {
+			return errors.Errorf("Hook %s is declared twice on configmap patchmanagement of %s", hook.Name, namespace)
+		}
+		names = append(names, hook.Name)
+	}
+
+	return nil
+}
+
+// phaseTimeout return the timeout of hooks of phase from configmap, or zero when not set
+func (j *Job) phaseTimeout(phase string) time.Duration {
+	if phase == HookPhasePre && j.PreJobTimeout > 0 {
+		return j.PreJobTimeout
+	}
+	if phase == HookPhasePost && j.PostJobTimeout > 0 {
+		return j.PostJobTimeout
+	}
+
+	return j.Timeout
+}
+
+// timeout return how many time the hook can run. The timeout of hook is used first, then the timeout of run
+func (h Hook) timeout(run HookRun) time.Duration {
+	if h.Timeout.Duration > 0 {
+		return h.Timeout.Duration
+	}
+	if run.Timeout > 0 {
+		return run.Timeout
+	}
+
+	return DefaultHookTimeout
+}
+
+func (h Hook) validate() error {
+	if errs := validation.IsDNS1123Label(hookJobPrefix + h.Name); len(errs) > 0 || h.Name == "" {
+		return errors.Errorf("Hook name %q must be a DNS label of at most %d characters", h.Name, validation.DNS1123LabelMaxLength-len(hookJobPrefix))
+	}
+	if h.Phase != HookPhasePre && h.Phase != HookPhasePost {
+		return errors.Errorf("Phase of hook %s must be %s or %s", h.Name, HookPhasePre, HookPhasePost)
+	}
+	switch h.Type {
+	case HookTypeJob:
+		if h.Script == "" {
+			return errors.Errorf("Script of hook %s must be provided", h.Name)
+		}
+	case HookTypeExec:
+		if err := validateHookExec(h); err != nil {
+			return err
+		}
+	case HookTypeWebhook:
+		if err := validateHookWebhook(h); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("Type of hook %s must be %s, %s or %s", h.Name, HookTypeJob, HookTypeExec, HookTypeWebhook)
+	}
+	if h.FailurePolicy != HookFailurePolicyAbort && h.FailurePolicy != HookFailurePolicyContinue {
+		return errors.Errorf("Failure policy of hook %s must be %s or %s", h.Name, HookFailurePolicyAbort, HookFailurePolicyContinue)
+	}
+	if h.Timeout.Duration < 0 {
+		return errors.Errorf("Timeout of hook %s must be positive", h.Name)
+	}
+	if err := validateHookVolumes(h); err != nil {
+		return err
+	}
+	if h.Template {
+		if _, err := h.parseTemplate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// jobName return the name of Job created by hook for the given run
+// The name is unique by run, node, phase and hook, and it is truncated to fit on DNS label
+func (h Hook) jobName(run HookRun) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{run.ID, run.NodeName, h.Phase, h.Name}, "/")))
+	suffix := "-" + hex.EncodeToString(sum[:])[:10]
+
+	name := hookJobPrefix + h.Name
+	if len(name)+len(suffix) > validation.DNS1123LabelMaxLength {
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)], "-")
+	}
+
+	return name + suffix
+}
+
+// podFailurePolicy return the policy that fail the Job of pre hook without retry when it veto the downtime
+func (h Hook) podFailurePolicy() *batch.PodFailurePolicy {
+	if h.Phase != HookPhasePre {
+		return nil
+	}
+
+	return &batch.PodFailurePolicy{
+		Rules: []batch.PodFailurePolicyRule{
+			{
+				Action: batch.PodFailurePolicyActionFailJob,
+				OnExitCodes: &batch.PodFailurePolicyOnExitCodesRequirement{
+					ContainerName: &h.Name,
+					Operator:      batch.PodFailurePolicyOnExitCodesOpIn,
+					Values:        []int32{HookVetoExitCode},
+				},
+			},
+		},
+	}
+}
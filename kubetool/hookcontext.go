@@ -0,0 +1,155 @@
+package kubetool
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"emperror.dev/errors"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultHookNodeLabels is the node labels given to hooks when no label is provided
+var DefaultHookNodeLabels = []string{
+	"topology.kubernetes.io/region",
+	"topology.kubernetes.io/zone",
+	"node.kubernetes.io/instance-type",
+	"node-role.kubernetes.io/*",
+}
+
+// HookContext is the context of hook run, given to the script as environment variables
+type HookContext struct {
+	Namespace   string
+	Phase       string
+	Hook        string
+	RunID       string
+	ClusterName string
+	NodeName    string
+
+	// Node is the node on maintenance and Zone its zone. They are empty when the hook is run without node
+	Node *core.Node
+	Zone string
+
+	// NodeLabels is the node labels of interest, see HookRun.NodeLabels
+	NodeLabels map[string]string
+
+	// Pods is the pods of namespace hosted on node
+	Pods []HookPod
+}
+
+// HookPod is the pod of namespace hosted on node
+type HookPod struct {
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Phase     core.PodPhase     `json:"phase"`
+	Ready     bool              `json:"ready"`
+	IP        string            `json:"ip,omitempty"`
+	OwnerKind string            `json:"ownerKind,omitempty"`
+	OwnerName string            `json:"ownerName,omitempty"`
+}
+
+// KubeconfigClusterName return the cluster name of current context of kubeconfig, or empty string if not found
+func KubeconfigClusterName(configPath string) string {
+	config, err := clientcmd.LoadFromFile(configPath)
+	if err != nil {
+		return ""
+	}
+	if kubeContext, ok := config.Contexts[config.CurrentContext]; ok {
+		return kubeContext.Cluster
+	}
+
+	return ""
+}
+
+// hookContext permit to compute the context of hook. The node labels and pods are empty when the hook is run without node
+func (k *Kubetool) hookContext(ctx context.Context, namespace string, hook Hook, run HookRun) (hookContext *HookContext, err error) {
+	hookContext = &HookContext{
+		Namespace:   namespace,
+		Phase:       hook.Phase,
+		Hook:        hook.Name,
+		RunID:       run.ID,
+		ClusterName: run.ClusterName,
+		NodeName:    run.NodeName,
+		NodeLabels:  map[string]string{},
+		Pods:        make([]HookPod, 0),
+	}
+	if run.NodeName == "" {
+		return hookContext, nil
+	}
+
+	node, err := k.client.CoreV1().Nodes().Get(ctx, run.NodeName, meta.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get node %s to run hook %s", run.NodeName, hook.Name)
+	}
+	hookContext.Node = node
+	hookContext.Zone = node.Labels[zoneLabel]
+	for key, value := range node.Labels {
+		if matchLabelKey(run.NodeLabels, key) {
+			hookContext.NodeLabels[key] = value
+		}
+	}
+
+	podList, err := k.client.CoreV1().Pods(namespace).List(ctx, meta.ListOptions{FieldSelector: "spec.nodeName=" + run.NodeName})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when list pods of %s on node %s to run hook %s", namespace, run.NodeName, hook.Name)
+	}
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != run.NodeName {
+			continue
+		}
+		hookPod := HookPod{
+			Name:   pod.Name,
+			Labels: pod.Labels,
+			Phase:  pod.Status.Phase,
+			Ready:  isPodReady(&pod),
+			IP:     pod.Status.PodIP,
+		}
+		if owner := meta.GetControllerOf(&pod); owner != nil {
+			hookPod.OwnerKind = owner.Kind
+			hookPod.OwnerName = owner.Name
+		}
+		hookContext.Pods = append(hookContext.Pods, hookPod)
+	}
+	sort.Slice(hookContext.Pods, func(i, j int) bool {
+		return hookContext.Pods[i].Name < hookContext.Pods[j].Name
+	})
+
+	return hookContext, nil
+}
+
+// env return the environment variables of hook container
+func (c *HookContext) env() (env []core.EnvVar, err error) {
+	nodeLabels, err := json.Marshal(c.NodeLabels)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := json.Marshal(c.Pods)
+	if err != nil {
+		return nil, err
+	}
+
+	return []core.EnvVar{
+		{Name: "NODE_NAME", Value: c.NodeName},
+		{Name: "KUBETOOL_NAMESPACE", Value: c.Namespace},
+		{Name: "KUBETOOL_PHASE", Value: c.Phase},
+		{Name: "KUBETOOL_HOOK", Value: c.Hook},
+		{Name: "KUBETOOL_RUN_ID", Value: c.RunID},
+		{Name: "KUBETOOL_CLUSTER_NAME", Value: c.ClusterName},
+		{Name: "KUBETOOL_NODE_LABELS", Value: string(nodeLabels)},
+		{Name: "KUBETOOL_PODS", Value: string(pods)},
+	}, nil
+}
+
+// matchLabelKey return true if the label key is on keys. The key ending by * match all labels with this prefix
+func matchLabelKey(keys []string, key string) bool {
+	for _, item := range keys {
+		if item == key || (strings.HasSuffix(item, "*") && strings.HasPrefix(key, strings.TrimSuffix(item, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
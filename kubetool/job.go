@@ -2,8 +2,10 @@ package kubetool
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"io"
+	"math"
 	"time"
 
 	"emperror.dev/errors"
@@ -13,11 +15,76 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-type logSync struct {
-	err  chan error
-	stop chan bool
+const (
+	// JobRunIDLabel is the label of hook Job with the ID of maintenance run that created it
+	JobRunIDLabel = "kubetool/run-id"
+
+	// JobNodeLabel is the label of hook Job with the node on maintenance
+	JobNodeLabel = "kubetool/node"
+
+	// JobPhaseLabel is the label of hook Job with the hook phase
+	JobPhaseLabel = "kubetool/phase"
+
+	// JobHookLabel is the label of hook Job with the hook name. All Jobs created by hooks have it
+	JobHookLabel = "kubetool/hook"
+)
+
+// HookRun is the maintenance run that lauch the hooks
+type HookRun struct {
+	// ID is the ID of maintenance run, shared by set-downtime and unset-downtime of node
+	ID string
+
+	// NodeName is the node on maintenance. It's empty when the hook is run alone
+	NodeName string
+
+	// ClusterName is the cluster name given to hooks
+	ClusterName string
+
+	// NodeLabels is the keys of node labels given to hooks. The key ending by * match all labels with this prefix
+	NodeLabels []string
+
+	// Timeout is the timeout of hooks without timeout. Zero to use DefaultHookTimeout
+	Timeout time.Duration
+
+	// TTLSecondsAfterFinished is set on hook Job when not nil, so Kubernetes delete it after it finished
+	TTLSecondsAfterFinished *int32
+
+	// History is how many hook Jobs are kept on namespace. Lower than 1 keep all of them
+	History int
+
+	// ArtifactsDir is the directory where the logs, the status and the events of hook Jobs are stored. Empty to not store them
+	ArtifactsDir string
+
+	// ArtifactsConfigMap is true to store the last logs and the status of hook Job on ConfigMap, next to the Job
+	ArtifactsConfigMap bool
+}
+
+// NewRunID return new ID of maintenance run. It can be used as label value
+func NewRunID() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		log.Warnf("Can't generate random run ID: %s", err.Error())
+	}
+
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(b))
+}
+
+// labels return the labels of hook Job
+func (r HookRun) labels(hook Hook) map[string]string {
+	labels := map[string]string{
+		JobRunIDLabel: r.ID,
+		JobPhaseLabel: hook.Phase,
+		JobHookLabel:  hook.Name,
+	}
+	// Node name can be longer than label value
+	if r.NodeName != "" && len(validation.IsValidLabelValue(r.NodeName)) == 0 {
+		labels[JobNodeLabel] = r.NodeName
+	}
+
+	return labels
 }
 
 type Job struct {
@@ -25,17 +92,51 @@ type Job struct {
 	SecretNames []string
 	PreJob      string
 	PostJob     string
+
+	// Order is the priority of job. Lower order run first for pre-job, and last for post-job
+	Order int
+
+	// After is the namespaces that must run their pre-job before this one, and their post-job after
+	After []string
+
+	// Timeout is the timeout of all hooks, PreJobTimeout and PostJobTimeout of the hooks of phase. Zero when not set
+	Timeout        time.Duration
+	PreJobTimeout  time.Duration
+	PostJobTimeout time.Duration
+
+	// Hooks is all hooks of namespace, including the legacy pre-job and post-job
+	Hooks []Hook
+
+	// Template is true when the scripts of all hooks are Go template
+	Template bool
+
+	// JobTemplate is the strategic merge patch applied on Job created by hooks
+	JobTemplate []byte
+
+	// SecretVolumes and ConfigMapVolumes are mounted as files on hook container
+	SecretVolumes    []HookVolume
+	ConfigMapVolumes []HookVolume
 }
 
-// RunJob permit to execute script as Job in kubernetes cluster
-func (k *Kubetool) RunJob(ctx context.Context, namespace string, jobName string, job string, image string, secrets []string, nodeName string) (err error) {
-	if job == "" {
+// RunJob permit to execute the script of hook as Job in kubernetes cluster
+// Each hook has its own Job by run, and the oldest Jobs are removed according to run history
+func (k *Kubetool) RunJob(ctx context.Context, namespace string, hook Hook, run HookRun) (err error) {
+	if hook.Script == "" {
 		log.Info("Empty job, skip it")
 		return err
 	}
 
-	longJobName := fmt.Sprintf("patchmanagement-%s", jobName)
-	backOffLimit := int32(4)
+	// The Job is stopped by Kubernetes after the timeout, and we wait a bit more so its failure is reported
+	timeout := hook.timeout(run)
+	ctx, cancelFunc := context.WithTimeout(ctx, timeout+hookDeadlineGrace)
+	defer cancelFunc()
+
+	// Check the secrets and configmaps exist before create the Job
+	if err = k.checkHookReferences(ctx, namespace, hook); err != nil {
+		return err
+	}
+
+	longJobName := hook.jobName(run)
 	deleteOption := meta.DeletePropagationForeground
 
 	// Check if old job already exist
@@ -49,7 +150,7 @@ func (k *Kubetool) RunJob(ctx context.Context, namespace string, jobName string,
 		}
 	}
 	if jobObj != nil {
-		log.Debugf("Found old job %s, try to remove it", longJobName)
+		log.Debugf("Found job %s of previous attempt, try to remove it", longJobName)
 		err := k.client.BatchV1().Jobs(namespace).Delete(ctx, longJobName, meta.DeleteOptions{PropagationPolicy: &deleteOption})
 		if err != nil {
 			return err
@@ -72,45 +173,98 @@ func (k *Kubetool) RunJob(ctx context.Context, namespace string, jobName string,
 		}
 	}
 
+	jobObj, err = k.newHookJob(ctx, namespace, longJobName, hook, run)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.client.BatchV1().Jobs(namespace).Create(ctx, jobObj, meta.CreateOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Remove the oldest Jobs when this one is finished
+	defer k.pruneJobs(namespace, longJobName, run.History)
+
+	// Wait job completion and read logs. The artifacts are stored after all logs are read
+	artifacts, err := newHookArtifacts(namespace, longJobName, hook, run)
+	if err != nil {
+		log.Warnf("Artifacts of hook %s for %s are not stored: %s", hook.Name, namespace, err.Error())
+	}
+	defer k.saveArtifacts(artifacts)
+	logs := k.followJobLogs(ctx, namespace, longJobName, artifacts)
+	defer logs.Stop()
+
+	if err = k.waitJob(ctx, namespace, longJobName, hook.Phase == HookPhasePre); err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewErrHookFailed(namespace, longJobName, "Timeout", fmt.Sprintf("hook %s not finished after %s", hook.Name, timeout))
+	}
+
+	return err
+}
+
+// newHookJob permit to compute the Job of hook for the given run, with the job-template of namespace
+func (k *Kubetool) newHookJob(ctx context.Context, namespace string, jobName string, hook Hook, run HookRun) (*batch.Job, error) {
+	activeDeadlineSeconds := int64(math.Ceil(hook.timeout(run).Seconds()))
+	backOffLimit := int32(4)
+
+	// Compute the environment variables from the context of hook
+	hookContext, err := k.hookContext(ctx, namespace, hook, run)
+	if err != nil {
+		return nil, err
+	}
+	env, err := hookContext.env()
+	if err != nil {
+		return nil, err
+	}
+	script, err := hookContext.render(hook)
+	if err != nil {
+		return nil, err
+	}
+
 	// Compte secret reference
-	secretList := make([]core.EnvFromSource, 0, len(secrets))
+	secretList := make([]core.EnvFromSource, 0, len(hook.Secrets))
 
-	for _, secret := range secrets {
+	for _, secret := range hook.Secrets {
 		secretList = append(secretList, core.EnvFromSource{
 			SecretRef: &core.SecretEnvSource{LocalObjectReference: core.LocalObjectReference{Name: secret}},
 		})
 	}
 
-	jobObj = &batch.Job{
+	volumes, volumeMounts := hookVolumes(hook)
+	labels := run.labels(hook)
+
+	jobObj := &batch.Job{
 		TypeMeta: meta.TypeMeta{
 			Kind: "Job",
 		},
 		ObjectMeta: meta.ObjectMeta{
-			Name: longJobName,
+			Name:   jobName,
+			Labels: labels,
 		},
 		Spec: batch.JobSpec{
-			BackoffLimit: &backOffLimit,
+			BackoffLimit:            &backOffLimit,
+			ActiveDeadlineSeconds:   &activeDeadlineSeconds,
+			TTLSecondsAfterFinished: run.TTLSecondsAfterFinished,
+			PodFailurePolicy:        hook.podFailurePolicy(),
 			Template: core.PodTemplateSpec{
 				ObjectMeta: meta.ObjectMeta{
-					Name: jobName,
+					Name:   hook.Name,
+					Labels: labels,
 				},
 				Spec: core.PodSpec{
 					RestartPolicy: "Never",
+					Volumes:       volumes,
 					Containers: []core.Container{
 						{
-							Name:  jobName,
-							Image: image,
+							Name:  hook.Name,
+							Image: hook.Image,
 							Command: []string{
 								"/bin/sh",
 							},
-							Args: []string{"-c", job},
-							Env: []core.EnvVar{
-								{
-									Name:  "NODE_NAME",
-									Value: nodeName,
-								},
-							},
-							EnvFrom: secretList,
+							Args:         []string{"-c", script},
+							Env:          env,
+							EnvFrom:      secretList,
+							VolumeMounts: volumeMounts,
 							Resources: core.ResourceRequirements{
 								Limits: core.ResourceList{
 									"cpu":    resource.MustParse("500m"),
@@ -128,103 +282,9 @@ func (k *Kubetool) RunJob(ctx context.Context, namespace string, jobName string,
 		},
 	}
 
-	_, err = k.client.BatchV1().Jobs(namespace).Create(ctx, jobObj, meta.CreateOptions{})
-	if err != nil {
-		return err
-	}
-
-	// Wait job completion and read logs
-	ctrl := k.getLogs(ctx, namespace, longJobName)
-	for {
-		select {
-		case err := <-ctrl.err:
-			return err
-		default:
-			jobObj, err = k.client.BatchV1().Jobs(namespace).Get(ctx, longJobName, meta.GetOptions{})
-			if err != nil {
-				ctrl.stop <- true
-				return err
-			}
-
-			for _, condition := range jobObj.Status.Conditions {
-				if condition.Type == batch.JobFailed && condition.Status == core.ConditionTrue {
-					ctrl.stop <- true
-					return errors.Errorf("Job %s failed: %s", longJobName, condition.Reason)
-				} else if condition.Type == batch.JobComplete && condition.Status == core.ConditionTrue {
-					log.Debugf("Job %s/%s completed successfully", namespace, longJobName)
-					ctrl.stop <- true
-					return nil
-				}
-			}
-
-			time.Sleep(5 * time.Second)
-		}
-	}
-
-}
-
-func (k *Kubetool) getLogs(ctx context.Context, namespace string, podName string) (ctrl logSync) {
-	ctrl = logSync{
-		err:  make(chan error, 1),
-		stop: make(chan bool, 1),
+	if err = applyJobTemplate(jobObj, hook.JobTemplate, hook.Name); err != nil {
+		return nil, err
 	}
 
-	go func() {
-		podLogsOptions := &core.PodLogOptions{
-			Follow: true,
-		}
-
-		// Wait pod start
-		for {
-			select {
-			case <-ctx.Done():
-				ctrl.err <- errors.New("Timeout when read logs")
-				return
-			case <-ctrl.stop:
-				return
-			default:
-				podList, err := k.client.CoreV1().Pods(namespace).List(ctx, meta.ListOptions{LabelSelector: "job-name=" + podName})
-				if err != nil {
-					ctrl.err <- errors.Wrap(err, "Error when list pods")
-					return
-				}
-				for _, pod := range podList.Items {
-					req := k.client.CoreV1().Pods(namespace).GetLogs(pod.Name, podLogsOptions)
-					podLogs, err := req.Stream(ctx)
-					if err != nil {
-						log.Errorf("Error when open stream log :%s", err.Error())
-						continue
-					}
-					defer podLogs.Close()
-					buf := make([]byte, 2048)
-					log.Infof("Logs from pod %s:", pod.Name)
-					for {
-						select {
-						case <-ctx.Done():
-							ctrl.err <- errors.New("Timeout when read logs")
-							return
-						case <-ctrl.stop:
-							return
-						default:
-							n, err := podLogs.Read(buf)
-							if n == 0 {
-								continue
-							}
-							if err == io.EOF {
-								break
-							}
-							if err != nil {
-								ctrl.err <- errors.Wrap(err, "Error when read stream logs")
-								return
-							}
-							log.Infof("%s", string(buf[:n]))
-						}
-					}
-				}
-				time.Sleep(time.Second * 1)
-			}
-		}
-	}()
-
-	return ctrl
+	return jobObj, nil
 }
@@ -0,0 +1,244 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// jobResyncPeriod is how many time to wait before check again the Job when nothing is received from watches
+const jobResyncPeriod = 30 * time.Second
+
+// unrecoverableWaitingReasons is the container waiting reasons that never resolve without change the hook
+var unrecoverableWaitingReasons = []string{
+	"ErrImagePull",
+	"ImagePullBackOff",
+	"InvalidImageName",
+	"CreateContainerConfigError",
+}
+
+// waitJob permit to wait the Job of hook is finished
+// It check the Job each time the Job, its pods or its events change, and fail fast when its pod can't run
+// When canVeto is true, the hook that exit with HookVetoExitCode return error of type hookVetoed
+func (k *Kubetool) waitJob(ctx context.Context, namespace string, jobName string, canVeto bool) (err error) {
+	changes, stop := k.watchJob(ctx, namespace, jobName)
+	defer stop()
+
+	resync := time.NewTicker(jobResyncPeriod)
+	defer resync.Stop()
+
+	for {
+		done, err := k.checkJob(ctx, namespace, jobName, canVeto)
+		if err != nil || done {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "Error when wait job %s/%s", namespace, jobName)
+		case <-changes:
+		case <-resync.C:
+		}
+	}
+}
+
+// watchJob permit to be notified when the Job, its pods or its events change
+// The watches are opened again when they are closed. When they can't be opened, only the resync period is used
+func (k *Kubetool) watchJob(ctx context.Context, namespace string, jobName string) (changes <-chan struct{}, stop func()) {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	notify := make(chan struct{}, 1)
+
+	watchers := map[string]func() (watch.Interface, error){
+		"job": func() (watch.Interface, error) {
+			return k.client.BatchV1().Jobs(namespace).Watch(ctx, meta.ListOptions{FieldSelector: "metadata.name=" + jobName})
+		},
+		"pods": func() (watch.Interface, error) {
+			return k.client.CoreV1().Pods(namespace).Watch(ctx, meta.ListOptions{LabelSelector: "job-name=" + jobName})
+		},
+		"events": func() (watch.Interface, error) {
+			return k.client.CoreV1().Events(namespace).Watch(ctx, meta.ListOptions{FieldSelector: "involvedObject.name=" + jobName})
+		},
+	}
+
+	for name, open := range watchers {
+		go func(name string, open func() (watch.Interface, error)) {
+			for ctx.Err() == nil {
+				w, err := open()
+				if err != nil {
+					log.Debugf("Can't watch %s of job %s/%s, check it every %s: %s", name, namespace, jobName, jobResyncPeriod, err.Error())
+					return
+				}
+				for range w.ResultChan() {
+					select {
+					case notify <- struct{}{}:
+					default:
+					}
+				}
+				w.Stop()
+			}
+		}(name, open)
+	}
+
+	return notify, cancelFunc
+}
+
+// checkJob permit to read the Job and its pods, and return true when the Job is completed
+// It return error of type hookFailed when the Job failed or when its pod can't run, and error of type hookVetoed when its pod veto the downtime
+func (k *Kubetool) checkJob(ctx context.Context, namespace string, jobName string, canVeto bool) (done bool, err error) {
+	var job *batch.Job
+	err = k.retryPolicy.Do(ctx, fmt.Sprintf("get job %s/%s", namespace, jobName), IsTransientError, func() (err error) {
+		job, err = k.client.BatchV1().Jobs(namespace).Get(ctx, jobName, meta.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != core.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batch.JobComplete:
+			log.Debugf("Job %s/%s completed successfully", namespace, jobName)
+			return true, nil
+		case batch.JobFailed:
+			pods, err := k.jobPods(ctx, namespace, jobName)
+			if err != nil {
+				return false, err
+			}
+			if vetoed, reason := vetoReason(pods); canVeto && vetoed {
+				return false, NewErrHookVetoed(fmt.Sprintf("Job %s/%s", namespace, jobName), reason)
+			}
+			return false, NewErrHookFailed(namespace, jobName, condition.Reason, terminationDetail(pods))
+		}
+	}
+
+	// Fail fast when the pod can't run, or when it veto the downtime and the Job not support pod failure policy
+	pods, err := k.jobPods(ctx, namespace, jobName)
+	if err != nil {
+		return false, err
+	}
+	if vetoed, reason := vetoReason(pods); canVeto && vetoed {
+		// The Job is still running, so it must not retry the hook while the node is uncordoned. It's suspended instead
+		// of deleted, so the vetoed pod, its logs and events are kept for the artifacts, until the Job is pruned
+		if _, err = k.client.BatchV1().Jobs(namespace).Patch(ctx, jobName, types.MergePatchType, []byte(`{"spec":{"suspend":true}}`), meta.PatchOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			log.Warnf("Error when suspend job %s/%s vetoed the downtime: %s", namespace, jobName, err.Error())
+		}
+		return false, NewErrHookVetoed(fmt.Sprintf("Job %s/%s", namespace, jobName), reason)
+	}
+	for _, pod := range pods {
+		if reason, detail := podFailure(&pod); reason != "" {
+			return false, NewErrHookFailed(namespace, jobName, reason, fmt.Sprintf("pod %s: %s", pod.Name, detail))
+		}
+	}
+
+	// Fail fast when the pod can't be created, like when quota is exceeded
+	if len(pods) == 0 {
+		eventList, err := k.client.CoreV1().Events(namespace).List(ctx, meta.ListOptions{FieldSelector: "involvedObject.name=" + jobName})
+		if err != nil {
+			return false, errors.Wrapf(err, "Error when list events of job %s/%s", namespace, jobName)
+		}
+		for _, event := range eventList.Items {
+			if event.InvolvedObject.Kind != "Job" || event.InvolvedObject.Name != jobName || event.InvolvedObject.UID != job.UID {
+				continue
+			}
+			if event.Reason == "FailedCreate" && strings.Contains(event.Message, "forbidden") {
+				return false, NewErrHookFailed(namespace, jobName, event.Reason, event.Message)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// jobPods return the pods of Job, the most recent first
+func (k *Kubetool) jobPods(ctx context.Context, namespace string, jobName string) (pods []core.Pod, err error) {
+	podList, err := k.client.CoreV1().Pods(namespace).List(ctx, meta.ListOptions{LabelSelector: "job-name=" + jobName})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when list pods of job %s/%s", namespace, jobName)
+	}
+
+	pods = podList.Items
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[j].CreationTimestamp.Before(&pods[i].CreationTimestamp)
+	})
+
+	return pods, nil
+}
+
+// podFailure return the reason why the pod can't run, or empty reason if the pod can run
+func podFailure(pod *core.Pod) (reason string, detail string) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == core.PodScheduled && condition.Status == core.ConditionFalse && condition.Reason == core.PodReasonUnschedulable {
+			return condition.Reason, condition.Message
+		}
+	}
+
+	statuses := append(append([]core.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		for _, waitingReason := range unrecoverableWaitingReasons {
+			if status.State.Waiting.Reason == waitingReason {
+				return waitingReason, fmt.Sprintf("container %s: %s", status.Name, status.State.Waiting.Message)
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// terminationDetail return the exit code and the termination message of the last failed container
+func terminationDetail(pods []core.Pod) string {
+	for _, pod := range pods {
+		statuses := append(append([]core.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated == nil || terminated.ExitCode == 0 {
+				continue
+			}
+
+			detail := fmt.Sprintf("container %s of pod %s exited with code %d", status.Name, pod.Name, terminated.ExitCode)
+			if message := strings.TrimSpace(terminated.Message); message != "" {
+				detail = fmt.Sprintf("%s: %s", detail, message)
+			}
+			return detail
+		}
+	}
+
+	return ""
+}
+
+// vetoReason return true and the termination message when a container of pods exited with HookVetoExitCode
+func vetoReason(pods []core.Pod) (vetoed bool, reason string) {
+	for _, pod := range pods {
+		statuses := append(append([]core.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			terminated := status.State.Terminated
+			if terminated == nil {
+				terminated = status.LastTerminationState.Terminated
+			}
+			if terminated != nil && terminated.ExitCode == HookVetoExitCode {
+				return true, strings.TrimSpace(terminated.Message)
+			}
+		}
+	}
+
+	return false, ""
+}
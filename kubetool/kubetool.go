@@ -1,13 +1,25 @@
 package kubetool
 
 import (
+	"io"
+	"os"
+
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Kubetool permit to connect on Kubernetes cluster
 type Kubetool struct {
-	client kubernetes.Interface
+	client      kubernetes.Interface
+	retryPolicy RetryPolicy
+	podExecutor PodExecutor
+
+	// config is nil when the connexion is not created from kube config
+	config *rest.Config
+
+	// out is where the drain print its progress
+	out io.Writer
 }
 
 // NewConnexion permit to connect on Kubernetes cluster from config file
@@ -23,14 +35,47 @@ func NewConnexion(configPath string) (cmd *Kubetool, err error) {
 	}
 
 	cmd = &Kubetool{
-		client: client,
+		client:      client,
+		retryPolicy: DefaultRetryPolicy(),
+		podExecutor: &spdyExecutor{client: client, config: config},
+		config:      config,
+		out:         os.Stdout,
 	}
 
 	return cmd, err
 }
 
+// NewConnexionFromClient permit to use the given client. The exec hooks need pod executor, see SetPodExecutor
 func NewConnexionFromClient(client kubernetes.Interface) (cmd *Kubetool) {
 	return &Kubetool{
-		client: client,
+		client:      client,
+		retryPolicy: DefaultRetryPolicy(),
+		podExecutor: &spdyExecutor{client: client},
+		out:         os.Stdout,
 	}
 }
+
+// SetPodExecutor permit to set how the exec hooks run command on pods
+func (k *Kubetool) SetPodExecutor(executor PodExecutor) {
+	k.podExecutor = executor
+}
+
+// SetRestConfig permit to set the config of API server, used by webhook hooks on Service
+func (k *Kubetool) SetRestConfig(config *rest.Config) {
+	k.config = config
+}
+
+// SetOutput permit to set where the drain print its progress. Default to stdout
+func (k *Kubetool) SetOutput(out io.Writer) {
+	k.out = out
+}
+
+// SetRetryPolicy permit to set the policy used to retry transient errors
+func (k *Kubetool) SetRetryPolicy(policy RetryPolicy) {
+	k.retryPolicy = policy
+}
+
+// RetryPolicy return the policy used to retry transient errors
+func (k *Kubetool) RetryPolicy() RetryPolicy {
+	return k.retryPolicy
+}
@@ -0,0 +1,151 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	coordination "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	lockLeasePrefix    = "kubetool-downtime"
+	lockLabel          = "kubetool/lock"
+	lockLabelValue     = "downtime"
+	LockNodeAnnotation = "kubetool/node"
+)
+
+// AcquireLock permit to take a cluster wide lock before put node on downtime
+// The lock is a Lease on given namespace. At most maxHolders leases can exist at the same time.
+// If the node already hold a lease, it reuse it.
+func (k *Kubetool) AcquireLock(ctx context.Context, namespace string, holder string, nodeName string, maxHolders int) (err error) {
+	log.Debugf("Namespace: %s, holder: %s, nodeName: %s", namespace, holder, nodeName)
+
+	leases, err := k.listLocks(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	holders := make([]string, 0, len(leases))
+	usedSlots := map[string]bool{}
+	for _, lease := range leases {
+		if lease.Annotations[LockNodeAnnotation] == nodeName {
+			log.Infof("Node %s already hold the lock %s/%s", nodeName, namespace, lease.Name)
+			return nil
+		}
+		usedSlots[lease.Name] = true
+		holders = append(holders, fmt.Sprintf("%s (%s)", lease.Annotations[LockNodeAnnotation], stringValue(lease.Spec.HolderIdentity)))
+	}
+
+	// The leases can use slots over maxHolders, when it was lowered while nodes hold the lock
+	if len(leases) >= maxHolders {
+		return NewErrLocked(namespace, holders)
+	}
+
+	for i := 0; i < maxHolders; i++ {
+		name := fmt.Sprintf("%s-%d", lockLeasePrefix, i)
+		if usedSlots[name] {
+			continue
+		}
+
+		now := metav1.NewMicroTime(time.Now())
+		lease := &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					lockLabel: lockLabelValue,
+				},
+				Annotations: map[string]string{
+					LockNodeAnnotation: nodeName,
+				},
+			},
+			Spec: coordination.LeaseSpec{
+				HolderIdentity: &holder,
+				AcquireTime:    &now,
+			},
+		}
+		_, err = k.client.CoordinationV1().Leases(namespace).Create(ctx, lease, metav1.CreateOptions{})
+		if err != nil {
+			if kerrors.IsAlreadyExists(err) {
+				// Someone take this slot at the same time
+				log.Debugf("Lease %s/%s already taken", namespace, name)
+				continue
+			}
+			return err
+		}
+
+		log.Infof("Lock %s/%s acquired by %s for node %s", namespace, name, holder, nodeName)
+		return nil
+	}
+
+	return NewErrLocked(namespace, holders)
+}
+
+// ReleaseLock permit to release the lock hold by node
+func (k *Kubetool) ReleaseLock(ctx context.Context, namespace string, nodeName string) (err error) {
+	log.Debugf("Namespace: %s, nodeName: %s", namespace, nodeName)
+
+	leases, err := k.listLocks(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range leases {
+		if lease.Annotations[LockNodeAnnotation] != nodeName {
+			continue
+		}
+		if err = k.client.CoordinationV1().Leases(namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		log.Infof("Lock %s/%s released for node %s", namespace, lease.Name, nodeName)
+	}
+
+	return nil
+}
+
+// ForceReleaseLock permit to release the locks, even if the holder is not finished
+// If nodeName is empty, it release all locks
+func (k *Kubetool) ForceReleaseLock(ctx context.Context, namespace string, nodeName string) (releasedNodes []string, err error) {
+	log.Debugf("Namespace: %s, nodeName: %s", namespace, nodeName)
+
+	leases, err := k.listLocks(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	releasedNodes = make([]string, 0, len(leases))
+	for _, lease := range leases {
+		if nodeName != "" && lease.Annotations[LockNodeAnnotation] != nodeName {
+			continue
+		}
+		if err = k.client.CoordinationV1().Leases(namespace).Delete(ctx, lease.Name, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return nil, err
+		}
+		log.Warnf("Lock %s/%s hold by %s for node %s is released", namespace, lease.Name, stringValue(lease.Spec.HolderIdentity), lease.Annotations[LockNodeAnnotation])
+		releasedNodes = append(releasedNodes, lease.Annotations[LockNodeAnnotation])
+	}
+
+	return releasedNodes, nil
+}
+
+func (k *Kubetool) listLocks(ctx context.Context, namespace string) (leases []coordination.Lease, err error) {
+	leaseList, err := k.client.CoordinationV1().Leases(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", lockLabel, lockLabelValue),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return leaseList.Items, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,207 @@
+package kubetool
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// logPollPeriod is how many time to wait before look again the new pods of Job
+	logPollPeriod = time.Second
+
+	// logGracePeriod is how many time to wait the end of logs when the Job is finished
+	logGracePeriod = 5 * time.Second
+)
+
+// logFollower permit to print the logs of all pods of Job, one attempt after another
+type logFollower struct {
+	k         *Kubetool
+	namespace string
+	jobName   string
+
+	// out is where the logs are also written, prefixed by attempt
+	out io.Writer
+
+	// followed is the pods already printed
+	followed map[string]bool
+	attempt  int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// followJobLogs permit to print the logs of the pods of Job until Stop is called
+// The logs are line by line, prefixed by [namespace/pod#attempt]. They are also written on out
+func (k *Kubetool) followJobLogs(ctx context.Context, namespace string, jobName string, out io.Writer) *logFollower {
+	f := &logFollower{
+		k:         k,
+		namespace: namespace,
+		jobName:   jobName,
+		out:       out,
+		followed:  map[string]bool{},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(f.done)
+		f.run(ctx)
+	}()
+
+	return f
+}
+
+// Stop permit to stop follow the logs, once the Job is finished
+// It print the logs of the pods not yet printed, and wait at most the grace period
+func (f *logFollower) Stop() {
+	close(f.stop)
+	<-f.done
+}
+
+// run permit to follow the pods of Job, by order of creation
+func (f *logFollower) run(ctx context.Context) {
+	for {
+		select {
+		case <-f.stop:
+			f.flush(ctx)
+			return
+		default:
+		}
+
+		pods, err := f.startedPods(ctx)
+		if err != nil {
+			log.Warnf("Error when list pods of job %s/%s to read logs: %s", f.namespace, f.jobName, err.Error())
+		}
+		if len(pods) > 0 {
+			f.print(ctx, &pods[0], true)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.stop:
+			f.flush(ctx)
+			return
+		case <-time.After(logPollPeriod):
+		}
+	}
+}
+
+// flush permit to print the logs of the pods finished before they were followed
+func (f *logFollower) flush(ctx context.Context) {
+	ctx, cancelFunc := context.WithTimeout(ctx, logGracePeriod)
+	defer cancelFunc()
+
+	pods, err := f.startedPods(ctx)
+	if err != nil {
+		log.Warnf("Error when list pods of job %s/%s to read logs: %s", f.namespace, f.jobName, err.Error())
+		return
+	}
+	for i := range pods {
+		f.print(ctx, &pods[i], false)
+	}
+}
+
+// startedPods return the pods of Job not yet followed, that have started container. The oldest first
+func (f *logFollower) startedPods(ctx context.Context) (pods []core.Pod, err error) {
+	podList, err := f.k.client.CoreV1().Pods(f.namespace).List(ctx, meta.ListOptions{LabelSelector: "job-name=" + f.jobName})
+	if err != nil {
+		return nil, err
+	}
+
+	pods = make([]core.Pod, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		if !f.followed[pod.Name] && isPodStarted(&pod) {
+			pods = append(pods, pod)
+		}
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		if pods[i].CreationTimestamp.Equal(&pods[j].CreationTimestamp) {
+			return pods[i].Name < pods[j].Name
+		}
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+
+	return pods, nil
+}
+
+// print permit to print the logs of pod line by line until EOF
+// When follow is true, it stop at most the grace period after Stop is called
+func (f *logFollower) print(ctx context.Context, pod *core.Pod, follow bool) {
+	f.followed[pod.Name] = true
+	f.attempt++
+	prefix := fmt.Sprintf("[%s/%s#%d]", f.namespace, pod.Name, f.attempt)
+
+	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+	if follow {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-f.stop:
+				select {
+				case <-ctx.Done():
+				case <-time.After(logGracePeriod):
+					cancelFunc()
+				}
+			}
+		}()
+	}
+
+	stream, err := f.k.client.CoreV1().Pods(f.namespace).GetLogs(pod.Name, &core.PodLogOptions{Follow: follow}).Stream(ctx)
+	if err != nil {
+		log.Warnf("%s Error when open stream log: %s", prefix, err.Error())
+		return
+	}
+	defer stream.Close()
+
+	if err = printLines(stream, prefix, f.out); err != nil && ctx.Err() == nil {
+		log.Warnf("%s Error when read stream log: %s", prefix, err.Error())
+	}
+}
+
+// printLines permit to log each line read from reader, until EOF. The lines are also written on out
+func printLines(reader io.Reader, prefix string, out io.Writer) error {
+	buf := bufio.NewReader(reader)
+	for {
+		line, err := buf.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			log.Infof("%s %s", prefix, line)
+			if _, err := fmt.Fprintf(out, "%s %s\n", prefix, line); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// isPodStarted return true if one container of pod is running or terminated, so it has logs
+func isPodStarted(pod *core.Pod) bool {
+	if pod.Status.Phase == core.PodRunning || pod.Status.Phase == core.PodSucceeded || pod.Status.Phase == core.PodFailed {
+		return true
+	}
+	statuses := append(append([]core.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Running != nil || status.State.Terminated != nil {
+			return true
+		}
+	}
+
+	return false
+}
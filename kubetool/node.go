@@ -2,15 +2,73 @@ package kubetool
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"emperror.dev/errors"
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/kubectl/pkg/drain"
 )
 
+const (
+	DrainActionEvict       = "evict"
+	DrainActionDelete      = "delete"
+	DrainActionForceDelete = "force-delete"
+	DrainActionIgnore      = "ignore"
+	DrainActionSkip        = "skip"
+	DrainActionBlocked     = "blocked"
+)
+
+// DrainOptions is the options used to drain node
+type DrainOptions struct {
+	// Force permit to delete pods that declare no controller
+	Force bool
+
+	// DeleteEmptyDirData permit to delete pods with emptyDir volume
+	DeleteEmptyDirData bool
+
+	// GracePeriodSeconds is how long to wait for a pod to terminate. Negative value use the pod's terminationGracePeriodSeconds
+	GracePeriodSeconds int
+
+	// Timeout is how long to wait drain is finished
+	Timeout time.Duration
+
+	// PodSelector is the label selector to filter pods on node
+	PodSelector string
+
+	// SkipWaitForDeleteTimeoutSeconds ignore pods that have a DeletionTimeStamp older than N seconds
+	SkipWaitForDeleteTimeoutSeconds int
+
+	// DisableEviction force drain to use delete rather than evict
+	DisableEviction bool
+}
+
+// DefaultDrainOptions return the default options used to drain node
+func DefaultDrainOptions() DrainOptions {
+	return DrainOptions{
+		Force:              true,
+		DeleteEmptyDirData: true,
+		GracePeriodSeconds: -1,
+		Timeout:            600 * time.Second,
+	}
+}
+
+// DrainPod represent what drain will do on pod
+type DrainPod struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Action    string            `json:"action"`
+	Reason    string            `json:"reason,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	OwnerKind string            `json:"ownerKind,omitempty"`
+	OwnerName string            `json:"ownerName,omitempty"`
+	Ready     bool              `json:"ready"`
+}
+
 // WorkerNodes permit to return the list of all worker nodes
 func (k *Kubetool) WorkerNodes(ctx context.Context) (nodes []string, err error) {
 	nodeList, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: "master!=true"})
@@ -53,8 +111,49 @@ func (k *Kubetool) Nodes(ctx context.Context) (nodes []string, err error) {
 	return nodes, err
 }
 
+// GetNode permit to get node object
+func (k *Kubetool) GetNode(ctx context.Context, nodeName string) (node *v1.Node, err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	return k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+}
+
+// UnavailableNodes permit to return the list of nodes not ready or not schedulable
+// Nodes handled by kubetool (with downtime state annotation) are not returned
+func (k *Kubetool) UnavailableNodes(ctx context.Context) (nodes []string, err error) {
+	nodeList, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nodes, err
+	}
+
+	for _, node := range nodeList.Items {
+		if _, ok := node.Annotations[DowntimeStateAnnotation]; ok {
+			continue
+		}
+		if node.Spec.Unschedulable || !isNodeReady(&node) {
+			nodes = append(nodes, node.Name)
+		}
+	}
+
+	return nodes, err
+}
+
+// SetNodeAnnotation permit to set annotation on node
+func (k *Kubetool) SetNodeAnnotation(ctx context.Context, nodeName string, key string, value string) (err error) {
+	log.Debugf("NodeName: %s, annotation: %s", nodeName, key)
+
+	return k.patchNodeAnnotation(ctx, nodeName, key, value)
+}
+
+// RemoveNodeAnnotation permit to remove annotation from node
+func (k *Kubetool) RemoveNodeAnnotation(ctx context.Context, nodeName string, key string) (err error) {
+	log.Debugf("NodeName: %s, annotation: %s", nodeName, key)
+
+	return k.patchNodeAnnotation(ctx, nodeName, key, nil)
+}
+
 // Drain permit to drain a node
-func (k *Kubetool) Drain(ctx context.Context, nodeName string, timeout time.Duration) (err error) {
+func (k *Kubetool) Drain(ctx context.Context, nodeName string, opts DrainOptions) (err error) {
 	log.Debugf("NodeName: %s", nodeName)
 
 	node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
@@ -63,20 +162,14 @@ func (k *Kubetool) Drain(ctx context.Context, nodeName string, timeout time.Dura
 	}
 	log.Debugf("Node %s found", node.Name)
 
-	drainer := &drain.Helper{
-		Ctx:                 ctx,
-		Client:              k.client,
-		DeleteEmptyDirData:  true,
-		IgnoreAllDaemonSets: true,
-		Timeout:             timeout,
-		GracePeriodSeconds:  -1,
-		Out:                 os.Stdout,
-		ErrOut:              os.Stderr,
-		Force:               true,
-	}
+	drainer := k.newDrainer(ctx, opts)
 
+	// Force delete pods stuck on terminating state
 	endGC := make(chan bool, 1)
 	go func() {
+		if !opts.Force {
+			return
+		}
 		defaultWaitTime := 30 * time.Second
 		for {
 			select {
@@ -98,62 +191,147 @@ func (k *Kubetool) Drain(ctx context.Context, nodeName string, timeout time.Dura
 	return err
 }
 
-// Cordon permit to cordon the node
-func (k *Kubetool) Cordon(ctx context.Context, nodeName string) (err error) {
+// PodsForDeletion permit to know what drain will do on each pod hosted on node, without delete them
+func (k *Kubetool) PodsForDeletion(ctx context.Context, nodeName string, opts DrainOptions) (pods []DrainPod, err error) {
 	log.Debugf("NodeName: %s", nodeName)
 
-	node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	drainer := k.newDrainer(ctx, opts)
+
+	podList, err := k.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Debugf("Node %s found", node.Name)
 
-	cordoner := drain.NewCordonHelper(node)
+	deleteList, errs := drainer.GetPodsForDeletion(nodeName)
+	if deleteList == nil {
+		return nil, errors.Combine(errs...)
+	}
+	podsToDelete := map[string]bool{}
+	for _, pod := range deleteList.Pods() {
+		podsToDelete[pod.Namespace+"/"+pod.Name] = true
+	}
 
-	if cordoner.UpdateIfRequired(true) {
-		log.Debugf("Cordon node %s", node.Name)
+	pods = make([]DrainPod, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		drainPod := DrainPod{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			Labels:    pod.Labels,
+			Ready:     isPodReady(&pod),
+		}
+		controllerRef := metav1.GetControllerOf(&pod)
+		if controllerRef != nil {
+			drainPod.OwnerKind = controllerRef.Kind
+			drainPod.OwnerName = controllerRef.Name
+		}
+
+		if podsToDelete[pod.Namespace+"/"+pod.Name] {
+			if controllerRef == nil {
+				drainPod.Action = DrainActionForceDelete
+				drainPod.Reason = "Pod declare no controller"
+			} else if drainer.DisableEviction {
+				drainPod.Action = DrainActionDelete
+			} else {
+				drainPod.Action = DrainActionEvict
+			}
+		} else if controllerRef != nil && controllerRef.Kind == "DaemonSet" {
+			drainPod.Action = DrainActionIgnore
+			drainPod.Reason = "DaemonSet-managed Pod"
+		} else {
+			drainPod.Action = DrainActionSkip
+			for _, e := range errs {
+				if strings.Contains(e.Error(), pod.Namespace+"/"+pod.Name) {
+					drainPod.Action = DrainActionBlocked
+					drainPod.Reason = e.Error()
+				}
+			}
+		}
+		pods = append(pods, drainPod)
+	}
+
+	return pods, nil
+}
+
+// newDrainer permit to get the drain helper
+func (k *Kubetool) newDrainer(ctx context.Context, opts DrainOptions) *drain.Helper {
+	return &drain.Helper{
+		Ctx:                             ctx,
+		Client:                          k.client,
+		DeleteEmptyDirData:              opts.DeleteEmptyDirData,
+		IgnoreAllDaemonSets:             true,
+		Timeout:                         opts.Timeout,
+		GracePeriodSeconds:              opts.GracePeriodSeconds,
+		PodSelector:                     opts.PodSelector,
+		SkipWaitForDeleteTimeoutSeconds: opts.SkipWaitForDeleteTimeoutSeconds,
+		DisableEviction:                 opts.DisableEviction,
+		Out:                             k.out,
+		ErrOut:                          os.Stderr,
+		Force:                           opts.Force,
+	}
+}
+
+// Cordon permit to cordon the node
+func (k *Kubetool) Cordon(ctx context.Context, nodeName string) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
 
-		err, err2 := cordoner.PatchOrReplace(k.client, false)
+	return k.retryPolicy.Do(ctx, fmt.Sprintf("cordon node %s", nodeName), IsTransientError, func() error {
+		node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
-		if err2 != nil {
-			return err2
+		log.Debugf("Node %s found", node.Name)
+
+		cordoner := drain.NewCordonHelper(node)
+
+		if cordoner.UpdateIfRequired(true) {
+			log.Debugf("Cordon node %s", node.Name)
+
+			err, err2 := cordoner.PatchOrReplace(k.client, false)
+			if err != nil {
+				return err
+			}
+			if err2 != nil {
+				return err2
+			}
+		} else {
+			log.Debugf("Node %s already cordoned", node.Name)
 		}
-	} else {
-		log.Debugf("Node %s already cordoned", node.Name)
-	}
 
-	return err
+		return nil
+	})
 }
 
 // Uncordon permit to ucordon the node
 func (k *Kubetool) Uncordon(ctx context.Context, nodeName string) (err error) {
 	log.Debugf("NodeName: %s", nodeName)
 
-	node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	log.Debugf("Node %s found", node.Name)
-
-	cordoner := drain.NewCordonHelper(node)
-
-	if cordoner.UpdateIfRequired(false) {
-		log.Debugf("Uncordon node %s", node.Name)
-
-		err, err2 := cordoner.PatchOrReplace(k.client, false)
+	return k.retryPolicy.Do(ctx, fmt.Sprintf("uncordon node %s", nodeName), IsTransientError, func() error {
+		node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
-		if err2 != nil {
-			return err2
+		log.Debugf("Node %s found", node.Name)
+
+		cordoner := drain.NewCordonHelper(node)
+
+		if cordoner.UpdateIfRequired(false) {
+			log.Debugf("Uncordon node %s", node.Name)
+
+			err, err2 := cordoner.PatchOrReplace(k.client, false)
+			if err != nil {
+				return err
+			}
+			if err2 != nil {
+				return err2
+			}
+		} else {
+			log.Debugf("Node %s already uncordoned", node.Name)
 		}
-	} else {
-		log.Debugf("Node %s already uncordoned", node.Name)
-	}
 
-	return err
+		return nil
+	})
 }
 
 // NodeOk permit to check if node is OK
@@ -166,15 +344,21 @@ func (k *Kubetool) NodeOk(ctx context.Context, nodeName string) (isOk bool, err
 	}
 	log.Debugf("Node %s found", node.Name)
 
-	isOk = false
+	isOk = isNodeReady(node)
+	if isOk {
+		log.Debugf("Node %s ready", nodeName)
+	}
+
+	return isOk, err
+
+}
+
+func isNodeReady(node *v1.Node) bool {
 	for _, condition := range node.Status.Conditions {
 		if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
-			log.Debugf("Node %s ready", nodeName)
-			isOk = true
-			break
+			return true
 		}
 	}
 
-	return isOk, err
-
+	return false
 }
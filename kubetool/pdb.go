@@ -0,0 +1,99 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// BlockedPod represent pod that can't be evicted right now because of PodDisruptionBudget
+type BlockedPod struct {
+	Namespace           string `json:"namespace"`
+	Name                string `json:"name"`
+	PodDisruptionBudget string `json:"podDisruptionBudget"`
+	Reason              string `json:"reason"`
+}
+
+// CheckDrain permit to check that all pods hosted on node can be evicted right now
+// It return the list of pods blocked by PodDisruptionBudget that can't allow any disruption
+func (k *Kubetool) CheckDrain(ctx context.Context, nodeName string, opts DrainOptions) (blockedPods []BlockedPod, err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	pods, err := k.PodsForDeletion(ctx, nodeName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group pods to evict by namespace
+	podsPerNamespace := map[string][]DrainPod{}
+	namespaces := make([]string, 0)
+	for _, pod := range pods {
+		// Pods deleted without eviction are not protected by PodDisruptionBudget
+		if pod.Action != DrainActionEvict && (pod.Action != DrainActionForceDelete || opts.DisableEviction) {
+			continue
+		}
+		if _, ok := podsPerNamespace[pod.Namespace]; !ok {
+			namespaces = append(namespaces, pod.Namespace)
+		}
+		podsPerNamespace[pod.Namespace] = append(podsPerNamespace[pod.Namespace], pod)
+	}
+
+	for _, namespace := range namespaces {
+		pdbs, err := k.client.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pdb := range pdbs.Items {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil {
+				return nil, err
+			}
+
+			// Pods on node covered by this pdb
+			matchingPods := make([]DrainPod, 0)
+			for _, pod := range podsPerNamespace[namespace] {
+				if selector.Matches(labels.Set(pod.Labels)) {
+					matchingPods = append(matchingPods, pod)
+				}
+			}
+			if len(matchingPods) == 0 {
+				continue
+			}
+			log.Debugf("PodDisruptionBudget %s/%s cover %d pods on node %s and allow %d disruptions", namespace, pdb.Name, len(matchingPods), nodeName, pdb.Status.DisruptionsAllowed)
+
+			if pdb.Status.DisruptionsAllowed >= int32(len(matchingPods)) {
+				continue
+			}
+			// The drain retry the eviction, so it only block when no disruption is allowed and the missing healthy pod is not on node:
+			// the pods on node are healthy, so their eviction can't be allowed until other pods become healthy
+			if pdb.Status.DisruptionsAllowed > 0 || hasUnhealthyPod(matchingPods) {
+				log.Warnf("PodDisruptionBudget %s/%s allow %d disruptions for %d pods on node %s (%d/%d healthy pods), the drain will retry the eviction", namespace, pdb.Name, pdb.Status.DisruptionsAllowed, len(matchingPods), nodeName, pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy)
+				continue
+			}
+			for _, pod := range matchingPods {
+				blockedPods = append(blockedPods, BlockedPod{
+					Namespace:           namespace,
+					Name:                pod.Name,
+					PodDisruptionBudget: pdb.Name,
+					Reason:              fmt.Sprintf("%d disruptions allowed for %d pods on node (%d/%d healthy pods)", pdb.Status.DisruptionsAllowed, len(matchingPods), pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy),
+				})
+			}
+		}
+	}
+
+	return blockedPods, nil
+}
+
+func hasUnhealthyPod(pods []DrainPod) bool {
+	for _, pod := range pods {
+		if !pod.Ready {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package kubetool
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// RetryPolicy is the policy used to retry actions that failed
+type RetryPolicy struct {
+	// MaxAttempts is how many times the action is run, including the first one
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first retry
+	InitialDelay time.Duration
+
+	// Multiplier is applied on delay after each retry
+	Multiplier float64
+
+	// MaxDelay is the upper bound of delay
+	MaxDelay time.Duration
+
+	// Jitter is the random fraction of delay added on each retry (0 to 1)
+	Jitter float64
+}
+
+// DefaultRetryPolicy return the default retry policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: 2 * time.Second,
+		Multiplier:   2,
+		MaxDelay:     1 * time.Minute,
+		Jitter:       0.2,
+	}
+}
+
+// Do permit to run action until it succeed, it return not retryable error or attempts run out
+// It return error of type retryExhausted when attempts run out
+func (p RetryPolicy) Do(ctx context.Context, name string, isRetryable func(err error) bool, action func() error) (err error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	delay := p.InitialDelay
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = action(); err == nil {
+			return nil
+		}
+		if isRetryable != nil && !isRetryable(err) {
+			return err
+		}
+		if attempt == maxAttempts {
+			break
+		}
+
+		wait := p.jitter(delay)
+		log.Warnf("Attempt %d/%d of %s failed: %s, retry in %s", attempt, maxAttempts, name, err.Error(), wait)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		delay = p.next(delay)
+	}
+
+	return NewErrRetryExhausted(name, maxAttempts, err)
+}
+
+func (p RetryPolicy) next(delay time.Duration) time.Duration {
+	if p.Multiplier > 0 {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+func (p RetryPolicy) jitter(delay time.Duration) time.Duration {
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+
+	return delay + time.Duration(rand.Float64()*p.Jitter*float64(delay))
+}
+
+// IsTransientError return true if the error returned by API server is temporary and the call can be retried
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return kerrors.IsConflict(err) ||
+		kerrors.IsServerTimeout(err) ||
+		kerrors.IsTimeout(err) ||
+		kerrors.IsTooManyRequests(err) ||
+		kerrors.IsInternalError(err) ||
+		kerrors.IsServiceUnavailable(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsConnectionRefused(err) ||
+		utilnet.IsProbableEOF(err)
+}
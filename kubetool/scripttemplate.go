@@ -0,0 +1,82 @@
+package kubetool
+
+import (
+	"context"
+	"strings"
+	"text/template"
+
+	"emperror.dev/errors"
+)
+
+// zoneLabel is the node label that give its zone
+const zoneLabel = "topology.kubernetes.io/zone"
+
+// parseTemplate permit to parse the script of hook as Go template
+func (h Hook) parseTemplate() (*template.Template, error) {
+	return parseHookTemplate(h.Name, h.Script, "script of hook")
+}
+
+// parseHookTemplate permit to parse text of hook as Go template. The kind describe the text on error message
+// The missing keys are errors, so a typo not render an empty value
+func parseHookTemplate(hookName string, text string, kind string) (*template.Template, error) {
+	tmpl, err := template.New(hookName).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid template on %s %s", kind, hookName)
+	}
+
+	return tmpl, nil
+}
+
+// HasTemplate return true if the hook has template to render with the context of run: its script or the body of its webhook
+func (h Hook) HasTemplate() bool {
+	return h.Template || (h.Webhook != nil && h.Webhook.Body != "")
+}
+
+// render permit to compute the script of hook with the context of run. The script is returned as is when it's not template
+func (c *HookContext) render(hook Hook) (script string, err error) {
+	if !hook.Template {
+		return hook.Script, nil
+	}
+
+	tmpl, err := hook.parseTemplate()
+	if err != nil {
+		return "", err
+	}
+
+	return c.execute(tmpl, hook)
+}
+
+// execute permit to render the template of hook with the context of run
+func (c *HookContext) execute(tmpl *template.Template, hook Hook) (text string, err error) {
+	var sb strings.Builder
+	if err = tmpl.Execute(&sb, c); err != nil {
+		return "", errors.Wrapf(err, "Error when render template of hook %s", hook.Name)
+	}
+
+	return sb.String(), nil
+}
+
+// CheckHookTemplates permit to render the templates of hook for the given run, like RunHook do, and to merge its job-template
+// It permit to catch the template errors before run any hook
+func (k *Kubetool) CheckHookTemplates(ctx context.Context, namespace string, hook Hook, run HookRun) (err error) {
+	hookContext, err := k.hookContext(ctx, namespace, hook, run)
+	if err != nil {
+		return err
+	}
+	if _, err = hookContext.render(hook); err != nil {
+		return err
+	}
+	if hook.Webhook != nil {
+		if _, err = hookContext.renderBody(hook); err != nil {
+			return err
+		}
+	}
+	// The job-template is merged with the Job of hook, so it can't be checked before
+	if hook.Type != HookTypeExec && hook.Type != HookTypeWebhook && hook.Script != "" {
+		if _, err = k.newHookJob(ctx, namespace, hook.jobName(run), hook, run); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package kubetool
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DowntimeStateAnnotation is the node annotation where the downtime progress is stored
+	DowntimeStateAnnotation = "kubetool/downtime-state"
+
+	// DowntimeStateVersion is the current version of the downtime state format
+	DowntimeStateVersion = 1
+)
+
+// DowntimePhase represent the last completed phase of set-downtime / unset-downtime
+type DowntimePhase string
+
+const (
+	DowntimePhaseNone         DowntimePhase = ""
+	DowntimePhaseChecked      DowntimePhase = "checked"
+	DowntimePhaseCordoned     DowntimePhase = "cordoned"
+	DowntimePhasePreJobsDone  DowntimePhase = "pre-jobs-done"
+	DowntimePhaseDrained      DowntimePhase = "drained"
+	DowntimePhaseUncordoned   DowntimePhase = "uncordoned"
+	DowntimePhasePostJobsDone DowntimePhase = "post-jobs-done"
+)
+
+// downtimePhaseOrder permit to compare phases
+var downtimePhaseOrder = map[DowntimePhase]int{
+	DowntimePhaseNone:         0,
+	DowntimePhaseChecked:      1,
+	DowntimePhaseCordoned:     2,
+	DowntimePhasePreJobsDone:  3,
+	DowntimePhaseDrained:      4,
+	DowntimePhaseUncordoned:   5,
+	DowntimePhasePostJobsDone: 6,
+}
+
+// DowntimeState represent the progress of downtime stored on node annotation
+type DowntimeState struct {
+	Version          int               `json:"version"`
+	RunID            string            `json:"runId"`
+	Phase            DowntimePhase     `json:"phase"`
+	PreJobs          []string          `json:"preJobs,omitempty"`
+	PostJobs         []string          `json:"postJobs,omitempty"`
+	EvictedWorkloads []EvictedWorkload `json:"evictedWorkloads,omitempty"`
+	Workloads        []Workload        `json:"workloads,omitempty"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+}
+
+// NewDowntimeState return empty downtime state, with new run ID
+func NewDowntimeState() *DowntimeState {
+	return &DowntimeState{
+		Version:  DowntimeStateVersion,
+		RunID:    NewRunID(),
+		Phase:    DowntimePhaseNone,
+		PreJobs:  make([]string, 0),
+		PostJobs: make([]string, 0),
+	}
+}
+
+// IsAfter return true if the current phase is after or equal the given phase
+func (s *DowntimeState) IsAfter(phase DowntimePhase) bool {
+	return downtimePhaseOrder[s.Phase] >= downtimePhaseOrder[phase]
+}
+
+// IsDowntimePhase return true if the current phase is part of set-downtime
+func (s *DowntimeState) IsDowntimePhase() bool {
+	return s.Phase != DowntimePhaseNone && !s.IsAfter(DowntimePhaseUncordoned)
+}
+
+// HasPreHook return true if pre hook already run successfully on namespace
+func (s *DowntimeState) HasPreHook(namespace string, hook string) bool {
+	return contains(s.PreJobs, hookKey(namespace, hook))
+}
+
+// AddPreHook permit to record the pre hook that run successfully on namespace
+func (s *DowntimeState) AddPreHook(namespace string, hook string) {
+	if !s.HasPreHook(namespace, hook) {
+		s.PreJobs = append(s.PreJobs, hookKey(namespace, hook))
+	}
+}
+
+// HasPostHook return true if post hook already run successfully on namespace
+func (s *DowntimeState) HasPostHook(namespace string, hook string) bool {
+	return contains(s.PostJobs, hookKey(namespace, hook))
+}
+
+// AddPostHook permit to record the post hook that run successfully on namespace
+func (s *DowntimeState) AddPostHook(namespace string, hook string) {
+	if !s.HasPostHook(namespace, hook) {
+		s.PostJobs = append(s.PostJobs, hookKey(namespace, hook))
+	}
+}
+
+func hookKey(namespace string, hook string) string {
+	return namespace + "/" + hook
+}
+
+// AddEvictedWorkloads permit to record the controllers of evicted pods
+// Controllers already recorded by previous drain keep their number of ready pods
+func (s *DowntimeState) AddEvictedWorkloads(workloads []EvictedWorkload) {
+	for _, workload := range workloads {
+		if indexWorkload(s.EvictedWorkloads, workload.Namespace, workload.Kind, workload.Name) < 0 {
+			s.EvictedWorkloads = append(s.EvictedWorkloads, workload)
+		}
+	}
+}
+
+// AddWorkloads permit to record the workloads that had pods on node
+// Workloads already recorded by previous drain keep their number of ready replicas
+func (s *DowntimeState) AddWorkloads(workloads []Workload) {
+	for _, workload := range workloads {
+		if !containsWorkload(s.Workloads, workload) {
+			s.Workloads = append(s.Workloads, workload)
+		}
+	}
+}
+
+// GetDowntimeState permit to read downtime state from node annotation
+// It return empty state if node not yet on downtime
+func (k *Kubetool) GetDowntimeState(ctx context.Context, nodeName string) (state *DowntimeState, err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	node, err := k.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := node.Annotations[DowntimeStateAnnotation]
+	if !ok || value == "" {
+		log.Debugf("No downtime state found on node %s", nodeName)
+		return NewDowntimeState(), nil
+	}
+
+	state = &DowntimeState{}
+	if err = json.Unmarshal([]byte(value), state); err != nil {
+		return nil, errors.Wrapf(err, "Error when decode annotation %s on node %s", DowntimeStateAnnotation, nodeName)
+	}
+	if state.Version != DowntimeStateVersion {
+		return nil, errors.Errorf("Unsupported downtime state version %d on node %s", state.Version, nodeName)
+	}
+	if _, ok := downtimePhaseOrder[state.Phase]; !ok {
+		return nil, errors.Errorf("Unknown downtime phase %s on node %s", state.Phase, nodeName)
+	}
+	if state.RunID == "" {
+		return nil, errors.Errorf("No run ID on downtime state of node %s", nodeName)
+	}
+
+	log.Debugf("Found downtime state on node %s: %s", nodeName, state.Phase)
+
+	return state, nil
+}
+
+// SetDowntimeState permit to store the downtime state on node annotation
+func (k *Kubetool) SetDowntimeState(ctx context.Context, nodeName string, state *DowntimeState) (err error) {
+	log.Debugf("NodeName: %s, phase: %s", nodeName, state.Phase)
+
+	state.Version = DowntimeStateVersion
+	state.UpdatedAt = time.Now().UTC()
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return k.SetNodeAnnotation(ctx, nodeName, DowntimeStateAnnotation, string(value))
+}
+
+// ClearDowntimeState permit to remove the downtime state from node annotation
+func (k *Kubetool) ClearDowntimeState(ctx context.Context, nodeName string) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	return k.RemoveNodeAnnotation(ctx, nodeName, DowntimeStateAnnotation)
+}
+
+// patchNodeAnnotation permit to set annotation on node. Nil value remove it.
+func (k *Kubetool) patchNodeAnnotation(ctx context.Context, nodeName string, key string, value any) (err error) {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]any{
+				key: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = k.client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return errors.Wrapf(err, "Error when patch annotation %s on node %s", key, nodeName)
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,134 @@
+package kubetool
+
+import (
+	"encoding/json"
+	"strings"
+
+	"emperror.dev/errors"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// parseJobTemplate permit to read the Job or PodTemplate YAML stored on key job-template of configmap
+// It return the template as strategic merge patch of Job
+func parseJobTemplate(namespace string, value string) (patch []byte, err error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	data, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid job-template on configmap patchmanagement of %s", namespace)
+	}
+	typeMeta := &metav1.TypeMeta{}
+	if err = json.Unmarshal(data, typeMeta); err != nil {
+		return nil, errors.Wrapf(err, "Invalid job-template on configmap patchmanagement of %s", namespace)
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Wrapf(err, "Invalid job-template on configmap patchmanagement of %s", namespace)
+	}
+	delete(raw, "apiVersion")
+	delete(raw, "kind")
+
+	switch typeMeta.Kind {
+	case "Job":
+		if err = yaml.UnmarshalStrict([]byte(value), &batch.Job{}); err != nil {
+			return nil, errors.Wrapf(err, "Invalid job-template on configmap patchmanagement of %s", namespace)
+		}
+		return json.Marshal(raw)
+	case "PodTemplate":
+		if err = yaml.UnmarshalStrict([]byte(value), &core.PodTemplate{}); err != nil {
+			return nil, errors.Wrapf(err, "Invalid job-template on configmap patchmanagement of %s", namespace)
+		}
+		return json.Marshal(map[string]any{
+			"spec": map[string]any{
+				"template": raw["template"],
+			},
+		})
+	default:
+		return nil, errors.Errorf("Kind of job-template on configmap patchmanagement of %s must be Job or PodTemplate", namespace)
+	}
+}
+
+// applyJobTemplate permit to merge the template with the Job generated by hook
+// The containers of template without name are merged with the hook container. The fields that handle the hook
+// failure and the veto can't be changed
+func applyJobTemplate(job *batch.Job, patch []byte, containerName string) (err error) {
+	if len(patch) == 0 {
+		return nil
+	}
+
+	patch, err = nameTemplateContainers(patch, containerName)
+	if err != nil {
+		return err
+	}
+
+	original, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, batch.Job{})
+	if err != nil {
+		return errors.Wrap(err, "Error when merge job-template")
+	}
+
+	name := job.Name
+	mergedJob := &batch.Job{}
+	if err = json.Unmarshal(merged, mergedJob); err != nil {
+		return errors.Wrap(err, "Error when merge job-template")
+	}
+
+	// The veto rely on pod failure policy, that need restartPolicy Never
+	managedFields := []struct {
+		name     string
+		original any
+		merged   any
+	}{
+		{"spec.backoffLimit", job.Spec.BackoffLimit, mergedJob.Spec.BackoffLimit},
+		{"spec.activeDeadlineSeconds", job.Spec.ActiveDeadlineSeconds, mergedJob.Spec.ActiveDeadlineSeconds},
+		{"spec.podFailurePolicy", job.Spec.PodFailurePolicy, mergedJob.Spec.PodFailurePolicy},
+		{"spec.template.spec.restartPolicy", job.Spec.Template.Spec.RestartPolicy, mergedJob.Spec.Template.Spec.RestartPolicy},
+	}
+	for _, field := range managedFields {
+		if !equality.Semantic.DeepEqual(field.original, field.merged) {
+			return errors.Errorf("Field %s of job-template can't be changed, it's managed by kubetool", field.name)
+		}
+	}
+
+	*job = *mergedJob
+
+	// The name is computed by kubetool
+	job.Name = name
+
+	return nil
+}
+
+// nameTemplateContainers permit to set the hook container name on template containers without name
+func nameTemplateContainers(patch []byte, containerName string) ([]byte, error) {
+	raw := map[string]any{}
+	if err := json.Unmarshal(patch, &raw); err != nil {
+		return nil, err
+	}
+	spec, _ := raw["spec"].(map[string]any)
+	podTemplate, _ := spec["template"].(map[string]any)
+	podSpec, _ := podTemplate["spec"].(map[string]any)
+	containers, _ := podSpec["containers"].([]any)
+	if len(containers) == 0 {
+		return patch, nil
+	}
+	for _, item := range containers {
+		if container, ok := item.(map[string]any); ok {
+			if name, _ := container["name"].(string); name == "" {
+				container["name"] = containerName
+			}
+		}
+	}
+
+	return json.Marshal(raw)
+}
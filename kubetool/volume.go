@@ -0,0 +1,114 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+	core "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HookVolume is Secret or ConfigMap mounted as files on hook container
+type HookVolume struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+// parseHookVolumes permit to read the volumes stored on key of configmap, as list of name:path separated by ;
+func parseHookVolumes(namespace string, key string, value string) (volumes []HookVolume, err error) {
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, mountPath, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, errors.Errorf("Invalid %s on configmap patchmanagement of %s: %s must be name:path", key, namespace, item)
+		}
+		volumes = append(volumes, HookVolume{
+			Name:      strings.TrimSpace(name),
+			MountPath: strings.TrimSpace(mountPath),
+		})
+	}
+
+	return volumes, nil
+}
+
+// validateHookVolumes permit to check the volumes have name and absolute mount path
+func validateHookVolumes(hook Hook) error {
+	mountPaths := make([]string, 0, len(hook.SecretVolumes)+len(hook.ConfigMapVolumes))
+	for _, volume := range append(append([]HookVolume{}, hook.SecretVolumes...), hook.ConfigMapVolumes...) {
+		if volume.Name == "" {
+			return errors.Errorf("Volume of hook %s must have name", hook.Name)
+		}
+		if !path.IsAbs(volume.MountPath) {
+			return errors.Errorf("Mount path of volume %s on hook %s must be absolute path", volume.Name, hook.Name)
+		}
+		if contains(mountPaths, path.Clean(volume.MountPath)) {
+			return errors.Errorf("Mount path %s is used twice on hook %s", volume.MountPath, hook.Name)
+		}
+		mountPaths = append(mountPaths, path.Clean(volume.MountPath))
+	}
+
+	return nil
+}
+
+// hookVolumes return the pod volumes and the container volume mounts of hook
+func hookVolumes(hook Hook) (volumes []core.Volume, mounts []core.VolumeMount) {
+	volumes = make([]core.Volume, 0, len(hook.SecretVolumes)+len(hook.ConfigMapVolumes))
+	mounts = make([]core.VolumeMount, 0, len(hook.SecretVolumes)+len(hook.ConfigMapVolumes))
+
+	for i, volume := range hook.SecretVolumes {
+		name := fmt.Sprintf("secret-%d", i)
+		volumes = append(volumes, core.Volume{
+			Name: name,
+			VolumeSource: core.VolumeSource{
+				Secret: &core.SecretVolumeSource{SecretName: volume.Name},
+			},
+		})
+		mounts = append(mounts, core.VolumeMount{Name: name, MountPath: volume.MountPath, ReadOnly: true})
+	}
+	for i, volume := range hook.ConfigMapVolumes {
+		name := fmt.Sprintf("configmap-%d", i)
+		volumes = append(volumes, core.Volume{
+			Name: name,
+			VolumeSource: core.VolumeSource{
+				ConfigMap: &core.ConfigMapVolumeSource{LocalObjectReference: core.LocalObjectReference{Name: volume.Name}},
+			},
+		})
+		mounts = append(mounts, core.VolumeMount{Name: name, MountPath: volume.MountPath, ReadOnly: true})
+	}
+
+	return volumes, mounts
+}
+
+// checkHookReferences permit to check the Secrets and ConfigMaps used by hook exist
+// So the Job is not created with pod stuck on CreateContainerConfigError
+func (k *Kubetool) checkHookReferences(ctx context.Context, namespace string, hook Hook) (err error) {
+	secrets := append([]string{}, hook.Secrets...)
+	for _, volume := range hook.SecretVolumes {
+		secrets = append(secrets, volume.Name)
+	}
+	for _, name := range secrets {
+		if _, err = k.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+			if kerrors.IsNotFound(err) {
+				return errors.Errorf("Secret %s used by hook %s not found on namespace %s", name, hook.Name, namespace)
+			}
+			return err
+		}
+	}
+
+	for _, volume := range hook.ConfigMapVolumes {
+		if _, err = k.client.CoreV1().ConfigMaps(namespace).Get(ctx, volume.Name, metav1.GetOptions{}); err != nil {
+			if kerrors.IsNotFound(err) {
+				return errors.Errorf("ConfigMap %s used by hook %s not found on namespace %s", volume.Name, hook.Name, namespace)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
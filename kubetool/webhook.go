@@ -0,0 +1,321 @@
+package kubetool
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+)
+
+// webhookMaxBodyLog is how many bytes of response body are printed and used as failure detail
+const webhookMaxBodyLog = 1024
+
+// webhookMethods is the HTTP methods allowed on webhook
+var webhookMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// HookWebhook is the HTTP request of webhook hook
+type HookWebhook struct {
+	// URL is the endpoint to call
+	URL string `json:"url,omitempty"`
+
+	// Service is the Service of namespace to call through the API server proxy, rather than URL. So it work when kubetool run outside the cluster
+	Service *HookWebhookService `json:"service,omitempty"`
+
+	// TLS is the TLS options used to call https URL
+	TLS *HookWebhookTLS `json:"tls,omitempty"`
+
+	// Method is the HTTP method. Default to POST
+	Method string `json:"method,omitempty"`
+
+	// Headers is the HTTP headers, their value can be read from Secret
+	Headers []HookWebhookHeader `json:"headers,omitempty"`
+
+	// Body is the request body, as Go template rendered with the context of run
+	Body string `json:"body,omitempty"`
+
+	// ExpectedStatus is the HTTP status of success. Default to all 2xx
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+}
+
+// HookWebhookHeader is the HTTP header of webhook. The value is read from the key of Secret on namespace when secretKeyRef is set
+type HookWebhookHeader struct {
+	Name         string                `json:"name"`
+	Value        string                `json:"value,omitempty"`
+	SecretKeyRef *HookWebhookSecretRef `json:"secretKeyRef,omitempty"`
+}
+
+// HookWebhookService is the Service called through the API server proxy
+type HookWebhookService struct {
+	Name string `json:"name"`
+
+	// Port is the name or the number of Service port. Default to the only port of Service
+	Port intstr.IntOrString `json:"port,omitempty"`
+
+	// Scheme is http or https. Default to http
+	Scheme string `json:"scheme,omitempty"`
+
+	// Path is the path and the query called on Service
+	Path string `json:"path,omitempty"`
+}
+
+// HookWebhookTLS is the TLS options of webhook
+type HookWebhookTLS struct {
+	// CASecretRef is the key of Secret on namespace with the PEM encoded CA certificates used to check the server. Default to the system CA
+	CASecretRef *HookWebhookSecretRef `json:"caSecretRef,omitempty"`
+
+	// InsecureSkipVerify is true to not check the server certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// HookWebhookSecretRef is the key of Secret
+type HookWebhookSecretRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// validateHookWebhook permit to check the HTTP request of webhook hook
+func validateHookWebhook(h Hook) error {
+	if h.Webhook == nil || (h.Webhook.URL == "" && h.Webhook.Service == nil) {
+		return errors.Errorf("URL or service of webhook hook %s must be provided", h.Name)
+	}
+	if h.Webhook.URL != "" && h.Webhook.Service != nil {
+		return errors.Errorf("Only one of URL or service of webhook hook %s can be provided", h.Name)
+	}
+	if h.Webhook.URL != "" {
+		u, err := url.Parse(h.Webhook.URL)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid URL of webhook hook %s", h.Name)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("URL of webhook hook %s must be http or https URL", h.Name)
+		}
+	}
+	if service := h.Webhook.Service; service != nil {
+		if service.Name == "" {
+			return errors.Errorf("Service name of webhook hook %s must be provided", h.Name)
+		}
+		if service.Scheme != "" && service.Scheme != "http" && service.Scheme != "https" {
+			return errors.Errorf("Service scheme of webhook hook %s must be http or https", h.Name)
+		}
+		if h.Webhook.TLS != nil {
+			return errors.Errorf("TLS of webhook hook %s can't be used with service, the API server call it", h.Name)
+		}
+	}
+	if hookTLS := h.Webhook.TLS; hookTLS != nil && hookTLS.CASecretRef != nil && (hookTLS.CASecretRef.Name == "" || hookTLS.CASecretRef.Key == "") {
+		return errors.Errorf("CA secretKeyRef of webhook hook %s must have name and key", h.Name)
+	}
+	if h.Webhook.Method != "" && !contains(webhookMethods, h.Webhook.Method) {
+		return errors.Errorf("Method of webhook hook %s must be one of %s", h.Name, strings.Join(webhookMethods, ", "))
+	}
+	for _, header := range h.Webhook.Headers {
+		if header.Name == "" {
+			return errors.Errorf("Header name of webhook hook %s must be provided", h.Name)
+		}
+		if header.SecretKeyRef != nil && (header.Value != "" || header.SecretKeyRef.Name == "" || header.SecretKeyRef.Key == "") {
+			return errors.Errorf("Header %s of webhook hook %s must have value or secretKeyRef with name and key", header.Name, h.Name)
+		}
+		// The Authorization header authenticate kubetool on API server
+		if h.Webhook.Service != nil && http.CanonicalHeaderKey(header.Name) == "Authorization" {
+			return errors.Errorf("Header Authorization of webhook hook %s can't be used with service", h.Name)
+		}
+	}
+	for _, status := range h.Webhook.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return errors.Errorf("Expected status %d of webhook hook %s is not HTTP status", status, h.Name)
+		}
+	}
+	if _, err := h.Webhook.parseBody(h.Name); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseBody permit to parse the body of webhook as Go template
+func (w *HookWebhook) parseBody(hookName string) (*template.Template, error) {
+	return parseHookTemplate(hookName, w.Body, "body of webhook hook")
+}
+
+// renderBody permit to compute the body of webhook with the context of run
+func (c *HookContext) renderBody(hook Hook) (body string, err error) {
+	tmpl, err := hook.Webhook.parseBody(hook.Name)
+	if err != nil {
+		return "", err
+	}
+
+	return c.execute(tmpl, hook)
+}
+
+// isExpectedStatus return true if the HTTP status is success for webhook
+func (w *HookWebhook) isExpectedStatus(status int) bool {
+	if len(w.ExpectedStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, expected := range w.ExpectedStatus {
+		if status == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunWebhook permit to call the HTTP endpoint of webhook hook
+// It failed when the endpoint can't be called before the timeout of hook, or when the HTTP status is not expected
+func (k *Kubetool) RunWebhook(ctx context.Context, namespace string, hook Hook, run HookRun) (err error) {
+	timeout := hook.timeout(run)
+	ctx, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	hookContext, err := k.hookContext(ctx, namespace, hook, run)
+	if err != nil {
+		return err
+	}
+	body, err := hookContext.renderBody(hook)
+	if err != nil {
+		return err
+	}
+
+	method := hook.Webhook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	client, endpoint, err := k.webhookClient(ctx, namespace, hook, timeout)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, strings.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "Error when create request of webhook hook %s for %s", hook.Name, namespace)
+	}
+	for _, header := range hook.Webhook.Headers {
+		value, err := k.webhookHeaderValue(ctx, namespace, hook, header)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(header.Name, value)
+	}
+
+	prefix := fmt.Sprintf("[%s/%s]", namespace, hook.Name)
+	log.Infof("%s Call %s %s", prefix, method, endpoint)
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return NewErrHookWebhookFailed(namespace, hook.Name, "Timeout", fmt.Sprintf("hook %s not finished after %s", hook.Name, timeout))
+		}
+		return NewErrHookWebhookFailed(namespace, hook.Name, "RequestFailed", err.Error())
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, webhookMaxBodyLog))
+	if err != nil {
+		log.Warnf("%s Error when read response body: %s", prefix, err.Error())
+	}
+	detail := strings.TrimSpace(strings.ToValidUTF8(string(respBody), string(utf8.RuneError)))
+	log.Infof("%s Response %s %s", prefix, resp.Status, detail)
+
+	if !hook.Webhook.isExpectedStatus(resp.StatusCode) {
+		return NewErrHookWebhookFailed(namespace, hook.Name, resp.Status, detail)
+	}
+
+	return nil
+}
+
+// webhookClient return the HTTP client and the URL of webhook
+// The Service is called through the API server proxy, with the transport of kube config. The URL is called with the TLS options of webhook
+func (k *Kubetool) webhookClient(ctx context.Context, namespace string, hook Hook, timeout time.Duration) (client *http.Client, endpoint string, err error) {
+	if service := hook.Webhook.Service; service != nil {
+		if k.config == nil {
+			return nil, "", errors.Errorf("Webhook hook %s on service need connexion from kube config", hook.Name)
+		}
+		transport, err := rest.TransportFor(k.config)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "Error when create transport of webhook hook %s", hook.Name)
+		}
+		serverURL, _, err := rest.DefaultServerUrlFor(k.config)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "Error when compute API server URL of webhook hook %s", hook.Name)
+		}
+		path, err := url.Parse(service.Path)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "Invalid service path of webhook hook %s", hook.Name)
+		}
+		endpointURL := serverURL.JoinPath("api/v1/namespaces", namespace, "services", service.proxyName(), "proxy", path.Path)
+		endpointURL.RawQuery = path.RawQuery
+
+		return &http.Client{Transport: transport, Timeout: timeout}, endpointURL.String(), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if hookTLS := hook.Webhook.TLS; hookTLS != nil {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: hookTLS.InsecureSkipVerify} // #nosec G402 -- explicitly asked on hook
+		if hookTLS.CASecretRef != nil {
+			ca, err := k.secretValue(ctx, namespace, hook, *hookTLS.CASecretRef)
+			if err != nil {
+				return nil, "", err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				return nil, "", errors.Errorf("No PEM certificate found on key %s of secret %s used by webhook hook %s on namespace %s", hookTLS.CASecretRef.Key, hookTLS.CASecretRef.Name, hook.Name, namespace)
+			}
+			transport.TLSClientConfig.RootCAs = pool
+		}
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, hook.Webhook.URL, nil
+}
+
+// proxyName return the name of Service used by API server proxy: scheme:name:port
+func (s *HookWebhookService) proxyName() string {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	if s.Port.String() == "" || s.Port.String() == "0" {
+		return fmt.Sprintf("%s:%s:", scheme, s.Name)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", scheme, s.Name, s.Port.String())
+}
+
+// webhookHeaderValue return the value of header, read from Secret when secretKeyRef is set
+func (k *Kubetool) webhookHeaderValue(ctx context.Context, namespace string, hook Hook, header HookWebhookHeader) (value string, err error) {
+	if header.SecretKeyRef == nil {
+		return header.Value, nil
+	}
+
+	return k.secretValue(ctx, namespace, hook, *header.SecretKeyRef)
+}
+
+// secretValue return the value of key of Secret used by webhook
+func (k *Kubetool) secretValue(ctx context.Context, namespace string, hook Hook, ref HookWebhookSecretRef) (value string, err error) {
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(ctx, ref.Name, meta.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "Error when get secret %s used by webhook hook %s on namespace %s", ref.Name, hook.Name, namespace)
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", errors.Errorf("Key %s not found on secret %s used by webhook hook %s on namespace %s", ref.Key, ref.Name, hook.Name, namespace)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,195 @@
+package kubetool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	log "github.com/sirupsen/logrus"
+	apps "k8s.io/api/apps/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	WorkloadKindDeployment  = "Deployment"
+	WorkloadKindStatefulSet = "StatefulSet"
+	WorkloadKindDaemonSet   = "DaemonSet"
+)
+
+// Workload represent Deployment, StatefulSet or DaemonSet that had pods on node
+type Workload struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+
+	// ReadyReplicas is the number of ready replicas of the workload before drain
+	ReadyReplicas int32 `json:"readyReplicas"`
+}
+
+// String return the workload as kind namespace/name
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+// SnapshotWorkloads permit to list the Deployments, StatefulSets and DaemonSets that have pods on node, with their number of ready replicas
+func (k *Kubetool) SnapshotWorkloads(ctx context.Context, nodeName string) (workloads []Workload, err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	podList, err := k.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	workloads = make([]Workload, 0)
+	for _, pod := range podList.Items {
+		controllerRef := metav1.GetControllerOf(&pod)
+		if controllerRef == nil {
+			continue
+		}
+
+		workload := Workload{
+			Namespace: pod.Namespace,
+			Kind:      controllerRef.Kind,
+			Name:      controllerRef.Name,
+		}
+		switch controllerRef.Kind {
+		case WorkloadKindStatefulSet, WorkloadKindDaemonSet:
+		case "ReplicaSet":
+			// The ReplicaSet managed by Deployment is named with the pod template hash as suffix
+			hash, ok := pod.Labels[apps.DefaultDeploymentUniqueLabelKey]
+			if !ok || !strings.HasSuffix(controllerRef.Name, "-"+hash) {
+				log.Debugf("ReplicaSet %s/%s not managed by Deployment, skip it", pod.Namespace, controllerRef.Name)
+				continue
+			}
+			workload.Kind = WorkloadKindDeployment
+			workload.Name = strings.TrimSuffix(controllerRef.Name, "-"+hash)
+		default:
+			continue
+		}
+
+		if !containsWorkload(workloads, workload) {
+			workloads = append(workloads, workload)
+		}
+	}
+
+	snapshot := make([]Workload, 0, len(workloads))
+	for _, workload := range workloads {
+		if _, _, workload.ReadyReplicas, err = k.workloadReplicas(ctx, workload); err != nil {
+			if kerrors.IsNotFound(err) {
+				log.Warnf("%s not found, skip it", workload)
+				continue
+			}
+			return nil, errors.Wrapf(err, "Error when get ready replicas of %s", workload)
+		}
+		snapshot = append(snapshot, workload)
+	}
+
+	return snapshot, nil
+}
+
+// WaitWorkloadsReady permit to wait the workloads are back to their desired ready replicas
+// A workload that was degraded before drain only need to be back to its ready replicas of before drain
+// Workloads removed in the meantime are ignored
+func (k *Kubetool) WaitWorkloadsReady(ctx context.Context, nodeName string, workloads []Workload, timeout time.Duration) (err error) {
+	log.Debugf("NodeName: %s", nodeName)
+
+	if timeout <= 0 || len(workloads) == 0 {
+		return waitGate(ctx, GateWorkloads, nodeName, timeout, nil, nil)
+	}
+
+	// All workloads share the same timeout
+	ctxWithTimeout, cancelFunc := context.WithTimeout(ctx, timeout)
+	defer cancelFunc()
+
+	for _, workload := range workloads {
+		workload := workload
+		check := func(ctx context.Context) (resourceVersion string, reason string, err error) {
+			resourceVersion, desired, ready, err := k.workloadReplicas(ctx, workload)
+			if err != nil {
+				if kerrors.IsNotFound(err) {
+					log.Warnf("%s not found, skip it", workload)
+					return "", "", nil
+				}
+				return "", "", err
+			}
+			expected := desired
+			if workload.ReadyReplicas < expected {
+				expected = workload.ReadyReplicas
+			}
+			if ready < expected {
+				return resourceVersion, fmt.Sprintf("%s has %d/%d ready replicas", workload, ready, expected), nil
+			}
+			return resourceVersion, "", nil
+		}
+		watcher := func(ctx context.Context, resourceVersion string) (watch.Interface, error) {
+			listOptions := metav1.ListOptions{
+				FieldSelector:   "metadata.name=" + workload.Name,
+				ResourceVersion: resourceVersion,
+			}
+			switch workload.Kind {
+			case WorkloadKindDeployment:
+				return k.client.AppsV1().Deployments(workload.Namespace).Watch(ctx, listOptions)
+			case WorkloadKindStatefulSet:
+				return k.client.AppsV1().StatefulSets(workload.Namespace).Watch(ctx, listOptions)
+			default:
+				return k.client.AppsV1().DaemonSets(workload.Namespace).Watch(ctx, listOptions)
+			}
+		}
+
+		if err = waitGate(ctxWithTimeout, GateWorkloads, nodeName, timeout, check, watcher); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// workloadReplicas return the desired and ready replicas of workload
+func (k *Kubetool) workloadReplicas(ctx context.Context, workload Workload) (resourceVersion string, desired int32, ready int32, err error) {
+	switch workload.Kind {
+	case WorkloadKindDeployment:
+		deployment, err := k.client.AppsV1().Deployments(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, 0, err
+		}
+		desired = 1
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+		return deployment.ResourceVersion, desired, deployment.Status.ReadyReplicas, nil
+	case WorkloadKindStatefulSet:
+		statefulset, err := k.client.AppsV1().StatefulSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, 0, err
+		}
+		desired = 1
+		if statefulset.Spec.Replicas != nil {
+			desired = *statefulset.Spec.Replicas
+		}
+		return statefulset.ResourceVersion, desired, statefulset.Status.ReadyReplicas, nil
+	case WorkloadKindDaemonSet:
+		daemonset, err := k.client.AppsV1().DaemonSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", 0, 0, err
+		}
+		return daemonset.ResourceVersion, daemonset.Status.DesiredNumberScheduled, daemonset.Status.NumberReady, nil
+	default:
+		return "", 0, 0, errors.Errorf("Workload kind %s not supported", workload.Kind)
+	}
+}
+
+func containsWorkload(workloads []Workload, workload Workload) bool {
+	for _, item := range workloads {
+		if item.Namespace == workload.Namespace && item.Kind == workload.Kind && item.Name == workload.Name {
+			return true
+		}
+	}
+
+	return false
+}
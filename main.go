@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/disaster37/kubetool/v1.28/cmd"
+	"github.com/disaster37/kubetool/v1.28/kubetool"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
@@ -55,13 +57,38 @@ func run(args []string) error {
 			Name:  "no-color",
 			Usage: "No print color",
 		},
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "retry-max-attempts",
+			Usage: "How many attempts when API server return transient error",
+			Value: 5,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "retry-initial-delay",
+			Usage: "The delay before the first retry",
+			Value: 2 * time.Second,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:  "retry-multiplier",
+			Usage: "The multiplier applied on delay after each retry",
+			Value: 2,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "retry-max-delay",
+			Usage: "The max delay between two retries",
+			Value: 1 * time.Minute,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:  "retry-jitter",
+			Usage: "The random fraction of delay added on each retry, between 0 and 1",
+			Value: 0.2,
+		}),
 	}
 	app.Commands = []*cli.Command{
 		{
 			Name:     "set-downtime",
 			Usage:    "Run pre action on node and set it on downtime",
 			Category: "Patchmanagement",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "node-name",
 					Usage: "The node name",
@@ -73,24 +100,132 @@ func run(args []string) error {
 				},
 				&cli.IntFlag{
 					Name:  "number-retry",
-					Usage: "How many retry",
+					Usage: "How many attempts when drain failed",
 					Value: 3,
 				},
-			},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only print what will be done, without change anything",
+					Value: false,
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "The output `FORMAT` of dry run and report: text or json",
+					Value: "text",
+				},
+				&cli.StringFlag{
+					Name:  "report-file",
+					Usage: "The `FILE` where write the JSON report",
+				},
+			}, append(append(drainFlags(), jobFlags()...), lockFlags(true)...)...),
+			Before: loadCommandConfig,
 			Action: cmd.SetDowntime,
 		},
 		{
 			Name:     "unset-downtime",
 			Usage:    "Unset downtime and run post action on node",
 			Category: "Patchmanagement",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "node-name",
 					Usage: "The node name",
 				},
-			},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only print what will be done, without change anything",
+					Value: false,
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "The output `FORMAT` of dry run and report: text or json",
+					Value: "text",
+				},
+				&cli.StringFlag{
+					Name:  "report-file",
+					Usage: "The `FILE` where write the JSON report",
+				},
+			}, append(append(gateFlags(), jobFlags()...), lockFlags(false)...)...),
+			Before: loadCommandConfig,
 			Action: cmd.UnsetDowntime,
 		},
+		{
+			Name:     "patch-cluster",
+			Usage:    "Patch all nodes one by one: set downtime, wait node is patched and unset downtime",
+			Category: "Patchmanagement",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "masters-order",
+					Usage: "`ORDER` to patch master nodes: first or last",
+					Value: "last",
+				},
+				&cli.IntFlag{
+					Name:  "max-unavailable",
+					Usage: "How many nodes can be unavailable at the same time",
+					Value: 1,
+				},
+				&cli.StringFlag{
+					Name:  "patched-signal",
+					Usage: "`SIGNAL` to know the node is patched: exec, annotation or boot-id",
+					Value: "exec",
+				},
+				&cli.StringFlag{
+					Name:  "patched-command",
+					Usage: "The command to exec to patch node when patched-signal is exec. The node name is available on NODE_NAME",
+				},
+				&cli.StringFlag{
+					Name:  "patched-annotation",
+					Usage: "The node annotation (key=value) to wait when patched-signal is annotation",
+					Value: "kubetool/patched=true",
+				},
+				&cli.DurationFlag{
+					Name:  "patched-timeout",
+					Usage: "How many time to wait node is patched",
+					Value: 1 * time.Hour,
+				},
+				&cli.BoolFlag{
+					Name:  "retry-on-drain-failed",
+					Usage: "Retry if drain failed",
+					Value: false,
+				},
+				&cli.IntFlag{
+					Name:  "number-retry",
+					Usage: "How many attempts when drain failed",
+					Value: 3,
+				},
+			}, append(append(append(drainFlags(), gateFlags()...), jobFlags()...), lockFlags(true)...)...),
+			Before: loadCommandConfig,
+			Action: cmd.PatchCluster,
+		},
+		{
+			Name:     "check-drain",
+			Usage:    "Check that node can be drained right now without be blocked by PodDisruptionBudget",
+			Category: "Patchmanagement",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "node-name",
+					Usage: "The node name",
+				},
+			}, drainFlags()...),
+			Before: loadCommandConfig,
+			Action: cmd.CheckDrain,
+		},
+		{
+			Name:     "force-release-lock",
+			Usage:    "Release the downtime lock, when the runner that hold it crash",
+			Category: "Patchmanagement",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "node-name",
+					Usage: "The node name that hold the lock. Release all locks if empty",
+				},
+				&cli.StringFlag{
+					Name:  "lock-namespace",
+					Usage: "The namespace where the lock leases are stored",
+					Value: "kube-system",
+				},
+			},
+			Action: cmd.ForceReleaseLock,
+		},
 		{
 			Name:     "list-master-nodes",
 			Usage:    "List master nodes on cluster",
@@ -136,24 +271,32 @@ func run(args []string) error {
 			Name:     "run-pre-job",
 			Usage:    "Run pre job from given namespace",
 			Category: "Patchmanagement",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "namespace",
 					Usage: "Namespace where found pre job to run",
 				},
-			},
+				&cli.StringFlag{
+					Name:  "node-name",
+					Usage: "The node name given to pre job, with its labels and the pods of namespace hosted on it",
+				},
+			}, hookJobFlags()...),
 			Action: cmd.RunPreJob,
 		},
 		{
 			Name:     "run-post-job",
 			Usage:    "Run post job from given namespace",
 			Category: "Patchmanagement",
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "namespace",
 					Usage: "Namespace where found post job to run",
 				},
-			},
+				&cli.StringFlag{
+					Name:  "node-name",
+					Usage: "The node name given to post job, with its labels and the pods of namespace hosted on it",
+				},
+			}, hookJobFlags()...),
 			Action: cmd.RunPostJob,
 		},
 		{
@@ -191,6 +334,163 @@ func run(args []string) error {
 	return err
 }
 
+// loadCommandConfig permit to load command flags from config file
+func loadCommandConfig(c *cli.Context) error {
+	if c.String("config") != "" {
+		before := altsrc.InitInputSourceWithContext(c.Command.Flags, altsrc.NewYamlSourceFromFlagFunc("config"))
+		return before(c)
+	}
+	return nil
+}
+
+// drainFlags return the flags used to drain node. They can be set on config file
+func drainFlags() []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  "drain-force",
+			Usage: "Delete pods that declare no controller",
+			Value: true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  "drain-delete-emptydir-data",
+			Usage: "Delete pods that use emptyDir volume",
+			Value: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "drain-grace-period",
+			Usage: "How many seconds to wait a pod terminate. Negative value use the pod's terminationGracePeriodSeconds",
+			Value: -1,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "drain-timeout",
+			Usage: "How many time to wait drain is finished",
+			Value: 600 * time.Second,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "drain-pod-selector",
+			Usage: "Only drain pods that match the label `SELECTOR`",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "drain-skip-wait-for-delete-timeout",
+			Usage: "Ignore pods that have a deletion timestamp older than N seconds. 0 to disable it",
+			Value: 0,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  "drain-disable-eviction",
+			Usage: "Delete pods rather than evict them, so PodDisruptionBudget is bypassed",
+			Value: false,
+		}),
+	}
+}
+
+// gateFlags return the timeout flags of readiness gates. They can be set on config file
+func gateFlags() []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "node-ready-timeout",
+			Usage: "How many time to wait node is ready before uncordon it. 0 to disable it",
+			Value: 10 * time.Minute,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "daemonset-ready-timeout",
+			Usage: "How many time to wait DaemonSet pods on node are ready. 0 to disable it",
+			Value: 5 * time.Minute,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "evicted-pods-ready-timeout",
+			Usage: "How many time to wait pods evicted by drain are rescheduled and ready. 0 to disable it",
+			Value: 10 * time.Minute,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "workloads-ready-timeout",
+			Usage: "How many time to wait Deployments, StatefulSets and DaemonSets that had pods on node are back to their desired ready replicas. 0 to disable it",
+			Value: 10 * time.Minute,
+		}),
+	}
+}
+
+// jobFlags return the flags of the namespace jobs run during downtime. They can be set on config file
+func jobFlags() []cli.Flag {
+	return append([]cli.Flag{
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "job-concurrency",
+			Usage: "How many namespace jobs run at the same time",
+			Value: 1,
+		}),
+	}, hookJobFlags()...)
+}
+
+// hookJobFlags return the flags of the Jobs created by hooks: their timeout, their history, their artifacts and the context given to them
+func hookJobFlags() []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "job-timeout",
+			Usage: "How many time the Jobs created by hooks can run, when the configmap not set their timeout",
+			Value: kubetool.DefaultHookTimeout,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "job-history",
+			Usage: "How many Jobs created by hooks are kept per namespace, 0 to keep all",
+			Value: 5,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "job-ttl",
+			Usage: "How many time Kubernetes keep the Jobs created by hooks after they finished, 0 to not set ttlSecondsAfterFinished",
+			Value: 0,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "artifacts-dir",
+			Usage: "The `DIRECTORY` where store the logs, the status and the events of Jobs created by hooks",
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  "artifacts-configmap",
+			Usage: "Store the last logs and the status of Jobs created by hooks on ConfigMap, next to the Job",
+			Value: false,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "cluster-name",
+			Usage: "The cluster name given to hooks. Default to the cluster of current context of kube config",
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:  "hook-node-labels",
+			Usage: "The node labels given to hooks. The label ending by * match all labels with this prefix",
+			Value: cli.NewStringSlice(kubetool.DefaultHookNodeLabels...),
+		}),
+	}
+}
+
+// lockFlags return the flags of the cluster wide lock
+func lockFlags(acquire bool) []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "lock",
+			Usage: "Use cluster wide lock, so only allowed number of nodes can be on downtime at the same time",
+			Value: true,
+		},
+		&cli.StringFlag{
+			Name:  "lock-namespace",
+			Usage: "The namespace where the lock leases are stored",
+			Value: "kube-system",
+		},
+	}
+
+	if acquire {
+		flags = append(flags,
+			&cli.StringFlag{
+				Name:  "lock-holder",
+				Usage: "The holder name of the lock. Default to hostname and pid",
+			},
+			&cli.IntFlag{
+				Name:  "lock-max-holders",
+				Usage: "How many nodes can hold the lock at the same time",
+				Value: 1,
+			},
+		)
+	}
+
+	return flags
+}
+
 func main() {
 	err := run(os.Args)
 	if err != nil {